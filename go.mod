@@ -6,7 +6,9 @@ require (
 	github.com/charmbracelet/bubbles v0.18.0
 	github.com/charmbracelet/bubbletea v0.26.6
 	github.com/charmbracelet/lipgloss v0.12.1
+	github.com/kevinburke/ssh_config v1.2.0
 	github.com/pelletier/go-toml/v2 v2.2.2
+	golang.org/x/crypto v0.25.0
 )
 
 require (
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Action is a user defined command, offered in the action menu and optionally bound to a key.
+type Action struct {
+	Name    string   `toml:"name"`
+	Key     string   `toml:"key" comment:"Optional key binding, ie 'ctrl+u'"`
+	Command string   `toml:"command" comment:"Command line to run, exclusive with script"`
+	Script  string   `toml:"script" comment:"Script to run with the host's shell, exclusive with command"`
+	Local   bool     `toml:"local" comment:"Run in the flake directory instead of on the host, with LABCOAT_* variables set"`
+	Confirm bool     `toml:"confirm" comment:"Ask for confirmation before running"`
+	Tags    []string `toml:"tags" comment:"Only offer for hosts with one of these tags, see hosts.tags-attr"`
+}
+
+// Matches is true if the action should be offered for a host with the specified tags.
+func (a Action) Matches(hostTags []string) bool {
+	if len(a.Tags) == 0 {
+		return true
+	}
+	for _, tag := range a.Tags {
+		if slices.Contains(hostTags, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateActions checks that each action is runnable, and that names are unique.
+func validateActions(actions []Action) error {
+	names := make(map[string]bool, len(actions))
+	for i, a := range actions {
+		if a.Name == "" {
+			return fmt.Errorf("commands.actions[%d]: name is required", i)
+		}
+		if names[a.Name] {
+			return fmt.Errorf("action %q: duplicate name", a.Name)
+		}
+		names[a.Name] = true
+
+		if (a.Command == "") == (a.Script == "") {
+			return fmt.Errorf("action %q: exactly one of command or script is required", a.Name)
+		}
+	}
+
+	return nil
+}
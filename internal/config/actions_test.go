@@ -0,0 +1,119 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/labcoat/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func load(t *testing.T, content string) (*config.Config, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return config.Load(path, true)
+}
+
+func TestLoadActions(t *testing.T) {
+	conf, err := load(t, `
+[[commands.actions]]
+name = "restart nginx"
+key = "ctrl+n"
+command = "systemctl restart nginx"
+confirm = true
+tags = ["web"]
+
+[[commands.actions]]
+name = "ping"
+script = "ping -c 1 $LABCOAT_DEPLOY_HOST"
+local = true
+`)
+	require.NoError(t, err)
+	require.Len(t, conf.Commands.Actions, 2)
+
+	a := conf.Commands.Actions[0]
+	assert.Equal(t, "systemctl restart nginx", a.Command)
+	assert.True(t, a.Confirm)
+	assert.True(t, a.Matches([]string{"db", "web"}))
+	assert.False(t, a.Matches([]string{"db"}))
+	assert.False(t, a.Matches(nil))
+
+	a = conf.Commands.Actions[1]
+	assert.True(t, a.Local)
+	assert.True(t, a.Matches(nil), "untagged actions match all hosts")
+}
+
+func TestLoadActionsInvalid(t *testing.T) {
+	tcs := map[string]string{
+		"name is required": `
+[[commands.actions]]
+command = "true"`,
+		"duplicate name": `
+[[commands.actions]]
+name = "a"
+command = "true"
+[[commands.actions]]
+name = "a"
+command = "false"`,
+		"exactly one of command or script": `
+[[commands.actions]]
+name = "a"
+command = "true"
+script = "true"`,
+	}
+
+	for want, content := range tcs {
+		t.Run(want, func(t *testing.T) {
+			_, err := load(t, content)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), want)
+		})
+	}
+}
+
+func TestKeyMapWithActions(t *testing.T) {
+	keys, err := config.DefaultKeyMap.WithActions([]config.Action{
+		{Name: "a", Key: "ctrl+u", Command: "true"},
+		{Name: "b", Command: "true"},
+	})
+	require.NoError(t, err)
+	require.Len(t, keys.Actions, 2)
+
+	msg := tea.KeyMsg{Type: tea.KeyCtrlU}
+	assert.True(t, key.Matches(msg, keys.Actions[0]))
+	assert.False(t, keys.Actions[1].Enabled())
+	assert.Contains(t, keys.FullHelp(), keys.Actions)
+	assert.Empty(t, config.DefaultKeyMap.Actions, "default key map must not be modified")
+}
+
+func TestKeyMapWithActionsConflict(t *testing.T) {
+	_, err := config.DefaultKeyMap.WithActions([]config.Action{
+		{Name: "a", Key: "d", Command: "true"},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"deploy"`)
+
+	_, err = config.DefaultKeyMap.WithActions([]config.Action{
+		{Name: "a", Key: "x", Command: "true"},
+		{Name: "b", Key: "x", Command: "true"},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"a"`)
+}
+
+func TestKeyMapWithActionsJobsKeys(t *testing.T) {
+	// Jobs view keys are not bound in the hosts view, where actions run.
+	keys, err := config.DefaultKeyMap.WithActions([]config.Action{
+		{Name: "a", Key: "enter", Command: "true"},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, keys.FullHelp(),
+		[]key.Binding{keys.JobOpen, keys.JobCancel, keys.JobRerun})
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
 )
@@ -14,32 +16,136 @@ type Config struct {
 	Commands Commands `toml:"commands"`
 	Hosts    Hosts    `toml:"hosts" comment:"Host deployment configuration. Nix attrs typically start with 'flake' or 'target'."`
 	Nix      Nix      `toml:"nix"`
+	Env      Env      `toml:"env" comment:"Environment of local commands, ie nixos-rebuild. Other variables are not passed."`
+	Health   Health   `toml:"health" comment:"Background polling of host health, displayed in the host list"`
+	Notify   Notify   `toml:"notify" comment:"Notifications when jobs finish, ie while working in another window"`
+	Timeouts Timeouts `toml:"timeouts" comment:"Maximum run time of each action type, 0s to disable"`
 }
 
 type General struct {
-	Pager string `toml:"pager"`
+	Pager        string   `toml:"pager"`
+	HoverDelay   Duration `toml:"hover-delay" comment:"Time a host must remain selected before hover-action runs"`
+	HoverAction  string   `toml:"hover-action" comment:"On hover: 'none', 'target' to fetch target info from nix, or 'status' to also collect host status"`
+	History      bool     `toml:"history" comment:"Record deploy and command output for the History tab"`
+	HistoryDir   string   `toml:"history-dir" comment:"Where history is recorded, defaults to $XDG_STATE_HOME/labcoat/history"`
+	HistoryLimit int      `toml:"history-limit" comment:"Runs kept per host, 0 to keep all"`
 }
 
 type Commands struct {
-	StatusCmds []string `toml:"status-cmds" comment:"List of commands to run to display host status"`
+	StatusCmds        []string            `toml:"status-cmds" comment:"List of commands to run to display host status"`
+	StatusCmdTimeout  Duration            `toml:"status-cmd-timeout" comment:"Timeout for each status command, 0s to disable"`
+	StatusCmdTimeouts map[string]Duration `toml:"status-cmd-timeouts" comment:"Per-command timeouts, keyed by command; overrides status-cmd-timeout"`
+	StatusRefresh     Duration            `toml:"status-refresh" comment:"Re-collect status while the Host Status tab is visible, 0s to disable"`
+	WatchInterval     Duration            `toml:"watch-interval" comment:"Default interval between runs of watched commands"`
+	SudoFor           []string            `toml:"sudo-for" comment:"Actions elevated with sudo when hosts.remote-sudo is enabled: 'deploy', 'status', 'reboot', 'run-command'. Remote commands.actions use 'run-command'"`
+	Actions           []Action            `toml:"actions,omitempty"` // Omitted so users may append [[commands.actions]] tables.
+}
+
+// StatusTimeout returns the timeout for the specified status command.
+func (c Commands) StatusTimeout(cmd string) time.Duration {
+	if d, ok := c.StatusCmdTimeouts[cmd]; ok {
+		return d.Std()
+	}
+
+	return c.StatusCmdTimeout.Std()
 }
 
 type Hosts struct {
 	DefaultSSHDomain string `toml:"default-ssh-domain" comment:"Appended after '.' to bare hostnames"`
 	DefaultSSHUser   string `toml:"default-ssh-user"`
-	DeployHostAttr   string `toml:"deploy-host-attr" comment:"Nix attr path for SSH deploy target hostname"`
+	DeployHostAttr   string `toml:"deploy-host-attr" comment:"Nix attr path for SSH deploy target [user@]host[:port], bracket IPv6 with a port"`
 	DeployUserAttr   string `toml:"deploy-user-attr"`
+	DefaultTransport string `toml:"default-transport" comment:"How to reach hosts: 'ssh', 'ssh-native', 'local', or 'machinectl'"`
+	TransportAttr    string `toml:"transport-attr" comment:"Nix attr path for per-host transport, overrides default-transport"`
+	SSHControlMaster bool   `toml:"ssh-control-master" comment:"Share one OpenSSH connection per host, including deploys"`
+	RemoteSudo       bool   `toml:"remote-sudo" comment:"Use sudo for commands.sudo-for actions when the deploy user is not root. Deploys with a sudo password require nixos-rebuild --ask-sudo-password support"`
+	DeployPreflight  bool   `toml:"deploy-preflight" comment:"Check connectivity, auth and sudo before deploying"`
+	DefaultJumpHost  string `toml:"default-jump-host" comment:"Connect to SSH hosts via this [user@]host[:port], as for ssh -J"`
+	JumpHostAttr     string `toml:"jump-host-attr" comment:"Nix attr path for per-host jump host, overrides default-jump-host; 'none' connects directly"`
+	HostKeysAttr     string `toml:"host-keys-attr" comment:"Nix attr path for the host's SSH public key(s) to pin, instead of using ~/.ssh/known_hosts"`
+	DefaultShell     string `toml:"default-shell" comment:"Shell that runs status scripts, sh is used if it is not installed on the host"`
+	ShellAttr        string `toml:"shell-attr" comment:"Nix attr path for per-host script shell, overrides default-shell"`
+	TagsAttr         string `toml:"tags-attr" comment:"Nix attr path for the host's tag(s), used to filter commands.actions"`
 }
 
 type Nix struct {
 	DefaultBuildHost string `toml:"default-build-host" comment:"Default [user@]host to run Nix builds on"`
 }
 
+type Env struct {
+	Pass  []string          `toml:"pass" comment:"Variables passed through from labcoat's environment when set"`
+	Extra map[string]string `toml:"extra" comment:"Additional variables, these override passed variables"`
+}
+
+type Health struct {
+	Interval    Duration `toml:"interval" comment:"Time between checks of each host, ie 1m; disabled by default. Target info of hosts not yet selected is fetched first"`
+	Method      string   `toml:"method" comment:"Reachability check before the SSH probe: 'icmp', 'tcp' (to the SSH port), or 'none'"`
+	Timeout     Duration `toml:"timeout" comment:"For each step of a check"`
+	Concurrency int      `toml:"concurrency" comment:"Maximum hosts checked at once"`
+	IdleAfter   Duration `toml:"idle-after" comment:"Pause polling after no key presses for this long, 0s to never pause. Polling also pauses while the terminal is unfocused"`
+}
+
+func (g General) validate() error {
+	switch g.HoverAction {
+	case "none", "target", "status":
+		return nil
+	}
+
+	return fmt.Errorf("general.hover-action: unknown action %q", g.HoverAction)
+}
+
+func (h Health) validate() error {
+	switch h.Method {
+	case "icmp", "tcp", "none":
+	default:
+		return fmt.Errorf("health.method: unknown method %q", h.Method)
+	}
+	if h.Concurrency < 1 {
+		return fmt.Errorf("health.concurrency: must be at least 1, got %d", h.Concurrency)
+	}
+
+	return nil
+}
+
+type Notify struct {
+	Actions     []string `toml:"actions" comment:"Actions to notify for: 'deploy', 'status', 'reboot', 'run-command'"`
+	MinDuration Duration `toml:"min-duration" comment:"Only notify for jobs that ran at least this long"`
+	Bell        bool     `toml:"bell" comment:"Ring the terminal bell"`
+	Terminal    string   `toml:"terminal" comment:"Terminal notification escape sequence: 'osc9', 'osc777', or '' to disable"`
+	Command     string   `toml:"command" comment:"Local sh command, ie notify-send \"$LABCOAT_SUMMARY\". LABCOAT_HOST, LABCOAT_ACTION, LABCOAT_RESULT, etc are set"`
+}
+
+// Enabled is true if any form of notification is configured.
+func (n Notify) Enabled() bool {
+	return n.Bell || n.Terminal != "" || n.Command != ""
+}
+
+func (n Notify) validate() error {
+	switch n.Terminal {
+	case "", "osc9", "osc777":
+		return nil
+	}
+
+	return fmt.Errorf("notify.terminal: unknown protocol %q", n.Terminal)
+}
+
+type Timeouts struct {
+	Status     Duration `toml:"status" comment:"Entire status script, see also commands.status-cmd-timeout"`
+	RunCommand Duration `toml:"run-command" comment:"Commands run from the Run Command tab, 0s to disable"`
+	Deploy     Duration `toml:"deploy"`
+	Reboot     Duration `toml:"reboot"`
+	RebootWait Duration `toml:"reboot-wait" comment:"Time for a rebooted host to come back up"`
+}
+
 // Default returns the default Config.
 func Default() Config {
 	return Config{
 		General: General{
-			Pager: "less",
+			Pager:        "less",
+			HoverDelay:   Duration(500 * time.Millisecond),
+			HoverAction:  "status",
+			History:      true,
+			HistoryLimit: 100,
 		},
 		Commands: Commands{
 			StatusCmds: []string{
@@ -50,15 +156,67 @@ func Default() Config {
 				"uptime",
 				"df -h -x tmpfs -x overlay",
 			},
+			StatusCmdTimeout:  Duration(30 * time.Second),
+			StatusCmdTimeouts: map[string]Duration{},
+			WatchInterval:     Duration(2 * time.Second),
+			SudoFor:           []string{"deploy", "reboot"},
 		},
 		Hosts: Hosts{
-			DefaultSSHUser: "root",
-			DeployHostAttr: "target.config.networking.fqdnOrHostName",
+			DefaultSSHUser:   "root",
+			DeployHostAttr:   "target.config.networking.fqdnOrHostName",
+			DefaultTransport: "ssh",
+			SSHControlMaster: true,
+			DeployPreflight:  true,
+			DefaultShell:     "bash",
 		},
 		Nix: Nix{
 			DefaultBuildHost: "localhost",
 		},
+		Env: Env{
+			Pass: []string{
+				"PATH",
+				"HOME",
+				"USER",
+				"LANG",
+				"LOCALE_ARCHIVE",
+				"TERM",
+				"TMPDIR",
+				"XDG_RUNTIME_DIR",
+				"SSH_AUTH_SOCK",
+				"NIX_CONFIG",
+				"NIX_PATH",
+				"NIX_REMOTE",
+			},
+			Extra: map[string]string{},
+		},
+		Health: Health{
+			Interval:    0, // Polling evaluates and probes every host, so is opt-in.
+			Method:      "tcp",
+			Timeout:     Duration(5 * time.Second),
+			Concurrency: 4,
+			IdleAfter:   Duration(15 * time.Minute),
+		},
+		Notify: Notify{
+			Actions:     []string{"deploy", "reboot", "run-command"},
+			MinDuration: Duration(30 * time.Second),
+		},
+		Timeouts: Timeouts{
+			Status:     Duration(5 * time.Minute),
+			RunCommand: 0, // Interactive and followed commands, ie journalctl -f, run indefinitely.
+			Deploy:     Duration(2 * time.Hour),
+			Reboot:     Duration(2 * time.Minute),
+			RebootWait: Duration(10 * time.Minute),
+		},
+	}
+}
+
+// UseSudo is true if the specified action should be elevated with sudo for deployUser.
+func (c Config) UseSudo(action string, deployUser string) bool {
+	if !c.Hosts.RemoteSudo || deployUser == "root" {
+		return false
 	}
+
+	return slices.Contains(c.Commands.SudoFor, action)
 }
 
 // PrintDefaults renders default config as TOML to stdout.
@@ -94,6 +252,18 @@ func Load(path string, mustExist bool) (*Config, error) {
 	if err = toml.Unmarshal(b, &conf); err != nil {
 		return nil, err
 	}
+	if err = validateActions(conf.Commands.Actions); err != nil {
+		return nil, err
+	}
+	if err = conf.General.validate(); err != nil {
+		return nil, err
+	}
+	if err = conf.Health.validate(); err != nil {
+		return nil, err
+	}
+	if err = conf.Notify.validate(); err != nil {
+		return nil, err
+	}
 
 	slog.Debug("Loaded config", "path", path)
 	return &conf, nil
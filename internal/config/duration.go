@@ -0,0 +1,27 @@
+package config
+
+import "time"
+
+// Duration is a time.Duration that is stored in TOML as a string, ie "30s" or "5m".
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// Std returns the standard library representation of this duration.
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
@@ -0,0 +1,28 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadHover(t *testing.T) {
+	conf, err := load(t, `
+[general]
+hover-delay = "1s"
+hover-action = "target"
+`)
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, conf.General.HoverDelay.Std())
+	assert.Equal(t, "target", conf.General.HoverAction)
+}
+
+func TestLoadHoverInvalid(t *testing.T) {
+	_, err := load(t, `
+[general]
+hover-action = "deploy"
+`)
+	assert.ErrorContains(t, err, "general.hover-action")
+}
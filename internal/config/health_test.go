@@ -0,0 +1,25 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadHealthInvalid(t *testing.T) {
+	tcs := map[string]string{
+		"health.method": `
+[health]
+method = "udp"`,
+		"health.concurrency": `
+[health]
+concurrency = 0`,
+	}
+
+	for want, content := range tcs {
+		t.Run(want, func(t *testing.T) {
+			_, err := load(t, content)
+			assert.ErrorContains(t, err, want)
+		})
+	}
+}
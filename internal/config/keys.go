@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+
 	"github.com/charmbracelet/bubbles/key"
 )
 
@@ -18,31 +20,103 @@ type KeyMap struct {
 	Pager      key.Binding
 
 	// Commands.
+	ActionMenu       key.Binding
+	AttachInput      key.Binding
+	CancelQueued     key.Binding
 	Deploy           key.Binding
+	DeployInput      key.Binding
+	CloseSession     key.Binding
 	Help             key.Binding
+	Jobs             key.Binding
+	NewSession       key.Binding
+	NextSession      key.Binding
+	OpenHistory      key.Binding
+	PrevSession      key.Binding
 	Reboot           key.Binding
 	RunCommandPrompt key.Binding
 	SSHInto          key.Binding
 	Status           key.Binding
+	Watch            key.Binding
 	Quit             key.Binding
+
+	// Jobs view.
+	JobOpen   key.Binding
+	JobCancel key.Binding
+	JobRerun  key.Binding
+
+	// Actions holds bindings for configured actions, in the same order as the config.  Bindings
+	// for actions without a key are disabled.
+	Actions []key.Binding
 }
 
 // FullHelp displays a full-screen list of all key bindings.
 func (k KeyMap) FullHelp() [][]key.Binding {
+	rows := append(k.hostsHelp(), []key.Binding{k.JobOpen, k.JobCancel, k.JobRerun})
+	if len(k.Actions) > 0 {
+		rows = append(rows, k.Actions)
+	}
+
+	return rows
+}
+
+// hostsHelp lists the key bindings of the hosts view, where actions are also bound.
+func (k KeyMap) hostsHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Up, k.Down, k.Left, k.Right, k.ScrollUp, k.ScrollDown, k.Jump, k.Filter},
-		{k.Status, k.Deploy, k.SSHInto, k.RunCommandPrompt, k.Reboot},
-		{k.Pager, k.Quit, k.Help},
+		{k.Status, k.Deploy, k.DeployInput, k.SSHInto, k.RunCommandPrompt, k.Watch, k.Reboot,
+			k.AttachInput, k.ActionMenu, k.CancelQueued},
+		{k.NewSession, k.PrevSession, k.NextSession, k.CloseSession},
+		{k.NextTab, k.OpenHistory, k.Jobs, k.Pager, k.Quit, k.Help},
+	}
+}
+
+// WithActions returns a copy of the KeyMap with bindings for the configured actions.  Action keys
+// may not conflict with each other, or with the existing bindings of the hosts view.  Jobs view
+// bindings may be reused, as actions are not available there.
+func (k KeyMap) WithActions(actions []Action) (KeyMap, error) {
+	used := make(map[string]string)
+	for _, rows := range k.hostsHelp() {
+		for _, b := range rows {
+			for _, bkey := range b.Keys() {
+				used[bkey] = b.Help().Desc
+			}
+		}
+	}
+	// Not displayed in help, but still handled.
+	used["ctrl+c"] = "cancel"
+	used["ctrl+\\"] = "exit"
+
+	k.Actions = make([]key.Binding, 0, len(actions))
+	for _, a := range actions {
+		if a.Key == "" {
+			k.Actions = append(k.Actions, key.NewBinding(key.WithDisabled()))
+			continue
+		}
+		if desc, ok := used[a.Key]; ok {
+			return k, fmt.Errorf("action %q: key %q is already bound to %q", a.Name, a.Key, desc)
+		}
+		used[a.Key] = a.Name
+
+		k.Actions = append(k.Actions, key.NewBinding(
+			key.WithKeys(a.Key),
+			key.WithHelp(a.Key, a.Name),
+		))
 	}
+
+	return k, nil
 }
 
 // ShortHelp displays one line of key bindings.
 func (k KeyMap) ShortHelp() []key.Binding {
-	return []key.Binding{
+	bindings := []key.Binding{
 		k.Up, k.Down, k.NextTab,
 		k.Status, k.Deploy, k.SSHInto, k.RunCommandPrompt, k.Reboot,
-		k.Help,
 	}
+	if len(k.Actions) > 0 {
+		bindings = append(bindings, k.ActionMenu)
+	}
+
+	return append(bindings, k.Help)
 }
 
 var DefaultKeyMap = KeyMap{
@@ -87,14 +161,54 @@ var DefaultKeyMap = KeyMap{
 		key.WithHelp("p", "open pager"),
 	),
 
+	ActionMenu: key.NewBinding(
+		key.WithKeys("m"),
+		key.WithHelp("m", "action menu"),
+	),
+	AttachInput: key.NewBinding(
+		key.WithKeys("a"),
+		key.WithHelp("a", "attach input"),
+	),
 	Deploy: key.NewBinding(
 		key.WithKeys("d"),
 		key.WithHelp("d", "deploy"),
 	),
+	DeployInput: key.NewBinding(
+		key.WithKeys("D"),
+		key.WithHelp("D", "deploy with input"),
+	),
+	CancelQueued: key.NewBinding(
+		key.WithKeys("c"),
+		key.WithHelp("c", "cancel queued"),
+	),
+	CloseSession: key.NewBinding(
+		key.WithKeys("x"),
+		key.WithHelp("x", "close session"),
+	),
 	Help: key.NewBinding(
 		key.WithKeys("?"),
 		key.WithHelp("?", "help"),
 	),
+	Jobs: key.NewBinding(
+		key.WithKeys("J"),
+		key.WithHelp("J", "jobs"),
+	),
+	NewSession: key.NewBinding(
+		key.WithKeys("n"),
+		key.WithHelp("n", "new session"),
+	),
+	NextSession: key.NewBinding(
+		key.WithKeys("]"),
+		key.WithHelp("]", "next session"),
+	),
+	OpenHistory: key.NewBinding(
+		key.WithKeys("o"),
+		key.WithHelp("o", "open history"),
+	),
+	PrevSession: key.NewBinding(
+		key.WithKeys("["),
+		key.WithHelp("[", "prev session"),
+	),
 	Reboot: key.NewBinding(
 		key.WithKeys("r"),
 		key.WithHelp("r", "reboot"),
@@ -111,8 +225,25 @@ var DefaultKeyMap = KeyMap{
 		key.WithKeys("s"),
 		key.WithHelp("s", "get status"),
 	),
+	Watch: key.NewBinding(
+		key.WithKeys("w"),
+		key.WithHelp("w", "watch cmd"),
+	),
 	Quit: key.NewBinding(
 		key.WithKeys("q"),
 		key.WithHelp("q", "quit"),
 	),
+
+	JobOpen: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter", "view job output"),
+	),
+	JobCancel: key.NewBinding(
+		key.WithKeys("x", "ctrl+c"),
+		key.WithHelp("x", "cancel job"),
+	),
+	JobRerun: key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "re-run job"),
+	),
 }
@@ -0,0 +1,40 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadNotify(t *testing.T) {
+	conf, err := load(t, `
+[notify]
+actions = ["deploy"]
+min-duration = "1m"
+terminal = "osc777"
+command = "notify-send labcoat \"$LABCOAT_SUMMARY\""
+`)
+	require.NoError(t, err)
+
+	n := conf.Notify
+	assert.True(t, n.Enabled())
+	assert.Equal(t, []string{"deploy"}, n.Actions)
+	assert.Equal(t, time.Minute, n.MinDuration.Std())
+	assert.False(t, n.Bell)
+}
+
+func TestLoadNotifyDefaults(t *testing.T) {
+	conf, err := load(t, "")
+	require.NoError(t, err)
+	assert.False(t, conf.Notify.Enabled(), "notifications are opt-in")
+}
+
+func TestLoadNotifyInvalid(t *testing.T) {
+	_, err := load(t, `
+[notify]
+terminal = "osc99"
+`)
+	assert.ErrorContains(t, err, "notify.terminal")
+}
@@ -0,0 +1,148 @@
+// Package health checks whether hosts are reachable on the network, and accepting SSH commands.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhillyerd/labcoat/internal/transport"
+)
+
+// State is the health of a host.
+type State int
+
+const (
+	StateUnknown     State = iota // Not yet checked.
+	StateReachable                // Accepting SSH commands.
+	StateUnreachable              // Not responding to ping, or TCP connections.
+	StateSSHFailing               // Reachable, but SSH commands fail.
+)
+
+// Reachability check methods, see Checker.Method.
+const (
+	MethodICMP = "icmp"
+	MethodTCP  = "tcp"
+	MethodNone = "none"
+)
+
+const (
+	DefaultTimeout = 5 * time.Second
+	DefaultSSHPort = 22
+)
+
+// Runs quickly on any host, without output.
+const probeCmd = "true"
+
+// Result is the outcome of a Check.
+type Result struct {
+	State   State
+	Err     error // Why the host is not healthy.
+	Checked time.Time
+}
+
+// Checker checks the reachability of hosts, then whether they accept SSH commands.
+type Checker struct {
+	Method  string        // Reachability check, ie MethodICMP.
+	Timeout time.Duration // For each step of a check.
+
+	// Ping checks whether host responds to ping, defaults to the ping command.
+	Ping func(ctx context.Context, host string) error
+
+	// Dial opens TCP connections, defaults to net.Dialer.
+	Dial func(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// NewChecker constructs a Checker using method to check reachability.
+func NewChecker(method string) *Checker {
+	return &Checker{
+		Method:  method,
+		Timeout: DefaultTimeout,
+		Ping:    Ping,
+		Dial:    (&net.Dialer{}).DialContext,
+	}
+}
+
+// Check checks that host is reachable on port, then that it accepts commands via t.  The
+// reachability check is skipped when host is empty, ie for hosts behind a jump host.
+func (c *Checker) Check(ctx context.Context, t transport.Transport, host string, port int) Result {
+	result := Result{State: StateReachable}
+	if host != "" {
+		if err := c.reachable(ctx, host, port); err != nil {
+			result.State, result.Err = StateUnreachable, err
+		}
+	}
+	if result.State == StateReachable {
+		if err := c.probeSSH(ctx, t); err != nil {
+			result.State, result.Err = StateSSHFailing, err
+		}
+	}
+	result.Checked = time.Now()
+
+	return result
+}
+
+func (c *Checker) reachable(ctx context.Context, host string, port int) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	switch c.Method {
+	case MethodICMP:
+		if err := c.Ping(ctx, host); err != nil {
+			return fmt.Errorf("ping %s: %w", host, err)
+		}
+	case MethodTCP:
+		if port == 0 {
+			port = DefaultSSHPort
+		}
+		conn, err := c.Dial(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			return err
+		}
+		_ = conn.Close()
+	}
+
+	return nil
+}
+
+func (c *Checker) probeSSH(ctx context.Context, t transport.Transport) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var stderr strings.Builder
+	proc := t.Command(ctx, probeCmd)
+	proc.SetStdin(strings.NewReader(""))
+	proc.SetStderr(&stderr)
+	if err := proc.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			line, _, _ := strings.Cut(msg, "\n")
+			return fmt.Errorf("%w: %s", err, line)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// String returns a short description of the state.
+func (s State) String() string {
+	switch s {
+	case StateReachable:
+		return "reachable"
+	case StateUnreachable:
+		return "unreachable"
+	case StateSSHFailing:
+		return "SSH failing"
+	}
+
+	return "unknown"
+}
+
+// Ping sends a single ICMP echo request to host, using the ping command.
+func Ping(ctx context.Context, host string) error {
+	return exec.CommandContext(ctx, "ping", "-c", "1", "-W", "1", host).Run()
+}
@@ -0,0 +1,98 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/jhillyerd/labcoat/internal/health"
+	"github.com/jhillyerd/labcoat/internal/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTransport fails commands with err, when set.
+func fakeTransport(err error) *transport.Fake {
+	return &transport.Fake{
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			if err != nil {
+				_, _ = io.WriteString(req.Stderr, "Permission denied (publickey).\n")
+			}
+			return err
+		},
+	}
+}
+
+func newChecker(method string, up bool) (*health.Checker, *[]string) {
+	var probed []string
+	c := health.NewChecker(method)
+	c.Ping = func(_ context.Context, host string) error {
+		probed = append(probed, "ping "+host)
+		if !up {
+			return errors.New("timeout")
+		}
+		return nil
+	}
+	c.Dial = func(_ context.Context, network string, address string) (net.Conn, error) {
+		probed = append(probed, network+" "+address)
+		if !up {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		_ = server.Close()
+		return client, nil
+	}
+
+	return c, &probed
+}
+
+func TestCheck(t *testing.T) {
+	sshErr := errors.New("exit status 255")
+	tcs := map[string]struct {
+		method string
+		host   string
+		port   int
+		up     bool
+		sshErr error
+		want   health.State
+		probed []string
+	}{
+		"icmp up":       {health.MethodICMP, "web1", 0, true, nil, health.StateReachable, []string{"ping web1"}},
+		"icmp down":     {health.MethodICMP, "web1", 0, false, nil, health.StateUnreachable, []string{"ping web1"}},
+		"tcp up":        {health.MethodTCP, "web1", 0, true, nil, health.StateReachable, []string{"tcp web1:22"}},
+		"tcp port":      {health.MethodTCP, "::1", 2222, true, nil, health.StateReachable, []string{"tcp [::1]:2222"}},
+		"tcp down":      {health.MethodTCP, "web1", 0, false, sshErr, health.StateUnreachable, []string{"tcp web1:22"}},
+		"ssh failing":   {health.MethodTCP, "web1", 0, true, sshErr, health.StateSSHFailing, []string{"tcp web1:22"}},
+		"none":          {health.MethodNone, "web1", 0, false, nil, health.StateReachable, nil},
+		"none failing":  {health.MethodNone, "web1", 0, false, sshErr, health.StateSSHFailing, nil},
+		"no host":       {health.MethodICMP, "", 0, false, nil, health.StateReachable, nil},
+		"no host fails": {health.MethodICMP, "", 0, false, sshErr, health.StateSSHFailing, nil},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			c, probed := newChecker(tc.method, tc.up)
+			got := c.Check(context.Background(), fakeTransport(tc.sshErr), tc.host, tc.port)
+
+			assert.Equal(t, tc.want, got.State, got.Err)
+			assert.Equal(t, tc.probed, *probed)
+			assert.False(t, got.Checked.IsZero())
+			if tc.want == health.StateReachable {
+				assert.NoError(t, got.Err)
+			} else {
+				assert.Error(t, got.Err)
+			}
+		})
+	}
+}
+
+func TestCheckSSHError(t *testing.T) {
+	c, _ := newChecker(health.MethodNone, true)
+	got := c.Check(context.Background(), fakeTransport(fmt.Errorf("exit status 255")), "", 0)
+
+	require.Error(t, got.Err)
+	assert.Equal(t, "exit status 255: Permission denied (publickey).", got.Err.Error())
+}
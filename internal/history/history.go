@@ -0,0 +1,201 @@
+// Package history persists records of commands and deployments run against hosts, including their
+// output.
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	recordExt = ".json"
+	outputExt = ".log"
+)
+
+// Record describes a single completed run.
+type Record struct {
+	ID          string    `json:"id"`
+	Host        string    `json:"host"`
+	Kind        string    `json:"kind"` // Type of run, ie deploy or run-command.
+	Command     string    `json:"command"`
+	Destination string    `json:"destination"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	State       string    `json:"state"`      // Final runner state, ie "Done" or "Failed".
+	Successful  bool      `json:"successful"` // Runner completed successfully.
+	ExitCode    int       `json:"exitCode"`   // -1 if the process did not exit normally.
+	FlakeRev    string    `json:"flakeRev"`   // Git revision of the flake, empty if unknown.
+}
+
+// Duration returns how long the run took.
+func (r Record) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// String returns a one line summary of the record.
+func (r Record) String() string {
+	return fmt.Sprintf("%s %s: %s", r.Start.Local().Format(time.DateTime), r.Kind, r.Command)
+}
+
+// Store saves records to a directory, one sub-directory per host.
+type Store struct {
+	dir   string
+	limit int // Records kept per host, older records are removed.
+}
+
+// NewStore constructs a store persisting to dir, which is created when needed.  Only the limit
+// most recent records are kept for each host, unless limit is zero.
+func NewStore(dir string, limit int) *Store {
+	return &Store{dir: dir, limit: limit}
+}
+
+// DefaultDir returns the history directory within $XDG_STATE_HOME.
+func DefaultDir() (string, error) {
+	stateRoot := os.Getenv("XDG_STATE_HOME")
+	if stateRoot == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateRoot = filepath.Join(home, ".local", "state")
+	}
+
+	return filepath.Join(stateRoot, "labcoat", "history"), nil
+}
+
+// Add saves rec and its output, returning the record with its ID assigned.
+func (s *Store) Add(rec Record, output []byte) (Record, error) {
+	dir := s.hostDir(rec.Host)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return rec, err
+	}
+
+	// IDs sort chronologically.
+	rec.ID = rec.Start.UTC().Format("20060102T150405.000000000Z") + "-" + url.PathEscape(rec.Kind)
+
+	if err := os.WriteFile(filepath.Join(dir, rec.ID+outputExt), output, 0o600); err != nil {
+		return rec, err
+	}
+
+	// Written last, so incomplete records are not listed.
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return rec, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, rec.ID+recordExt), b, 0o600); err != nil {
+		return rec, err
+	}
+
+	return rec, s.prune(rec.Host)
+}
+
+// List returns the records for host, most recent first.
+func (s *Store) List(host string) ([]Record, error) {
+	ids, err := s.ids(host)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		b, err := os.ReadFile(filepath.Join(s.hostDir(host), ids[i]+recordExt))
+		if err != nil {
+			return nil, err
+		}
+
+		var rec Record
+		if err := json.Unmarshal(b, &rec); err != nil {
+			slog.Warn("Skipping corrupt history record", "host", host, "id", ids[i], "err", err)
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+// OutputPath returns the path of the file containing the output of rec.
+func (s *Store) OutputPath(rec Record) string {
+	return filepath.Join(s.hostDir(rec.Host), rec.ID+outputExt)
+}
+
+// Output returns the output of rec.
+func (s *Store) Output(rec Record) ([]byte, error) {
+	return os.ReadFile(s.OutputPath(rec))
+}
+
+// ids returns the record IDs for host, oldest first.
+func (s *Store) ids(host string) ([]string, error) {
+	entries, err := os.ReadDir(s.hostDir(host))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ids []string
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), recordExt); ok && !e.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// prune removes the oldest records for host beyond the limit.
+func (s *Store) prune(host string) error {
+	ids, err := s.ids(host)
+	if err != nil || s.limit <= 0 || len(ids) <= s.limit {
+		return err
+	}
+
+	var errs []error
+	for _, id := range ids[:len(ids)-s.limit] {
+		for _, ext := range []string{recordExt, outputExt} {
+			err := os.Remove(filepath.Join(s.hostDir(host), id+ext))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Store) hostDir(host string) string {
+	return filepath.Join(s.dir, url.PathEscape(host))
+}
+
+// GitRevision returns the commit checked out in dir, suffixed with "-dirty" if there are
+// uncommitted changes.  Returns an empty string if dir is not a git work tree.
+func GitRevision(ctx context.Context, dir string) string {
+	rev, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		slog.Debug("Unable to determine flake git revision", "dir", dir, "err", err)
+		return ""
+	}
+	result := strings.TrimSpace(string(rev))
+
+	status, err := exec.CommandContext(ctx, "git", "-C", dir, "status", "--porcelain").Output()
+	if err != nil {
+		return result
+	}
+	if len(strings.TrimSpace(string(status))) > 0 {
+		result += "-dirty"
+	}
+
+	return result
+}
@@ -0,0 +1,120 @@
+package history_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/labcoat/internal/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func record(host string, start time.Time) history.Record {
+	return history.Record{
+		Host:       host,
+		Kind:       "run-command",
+		Command:    "uptime",
+		Start:      start,
+		End:        start.Add(2 * time.Second),
+		State:      "Done",
+		Successful: true,
+	}
+}
+
+func TestStoreAddList(t *testing.T) {
+	store := history.NewStore(t.TempDir(), 0)
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	first, err := store.Add(record("web", start), []byte("first\n"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, first.ID)
+	_, err = store.Add(record("web", start.Add(time.Minute)), []byte("second\n"))
+	require.NoError(t, err)
+	_, err = store.Add(record("db/1", start), []byte("other\n"))
+	require.NoError(t, err)
+
+	got, err := store.List("web")
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, start.Add(time.Minute), got[0].Start, "Most recent should be first")
+	assert.Equal(t, first, got[1])
+	assert.Equal(t, 2*time.Second, got[1].Duration())
+
+	output, err := store.Output(got[1])
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", string(output))
+
+	got, err = store.List("db/1")
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+
+	got, err = store.List("missing")
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestStoreLimit(t *testing.T) {
+	dir := t.TempDir()
+	store := history.NewStore(dir, 2)
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	var oldest history.Record
+	for i := range 3 {
+		rec, err := store.Add(record("web", start.Add(time.Duration(i)*time.Minute)), []byte("x"))
+		require.NoError(t, err)
+		if i == 0 {
+			oldest = rec
+		}
+	}
+
+	got, err := store.List("web")
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, start.Add(2*time.Minute), got[0].Start)
+	assert.NoFileExists(t, store.OutputPath(oldest))
+}
+
+func TestStoreSkipsCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	store := history.NewStore(dir, 0)
+	_, err := store.Add(record("web", time.Now()), nil)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "web", "bad.json"), []byte("{"), 0o600))
+
+	got, err := store.List("web")
+	require.NoError(t, err)
+	assert.Len(t, got, 1)
+}
+
+func TestGitRevision(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	assert.Empty(t, history.GitRevision(context.Background(), dir))
+
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	git("init", "-q")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "flake.nix"), []byte("{}"), 0o600))
+	git("add", "flake.nix")
+	git("commit", "-q", "-m", "init")
+
+	rev := history.GitRevision(context.Background(), dir)
+	assert.Len(t, rev, 40)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "flake.nix"), []byte("{ }"), 0o600))
+	assert.Equal(t, rev+"-dirty", history.GitRevision(context.Background(), dir))
+}
@@ -2,6 +2,7 @@ package nix
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"text/template"
 
 	"github.com/jhillyerd/labcoat/internal/config"
+	"github.com/jhillyerd/labcoat/internal/sshdest"
 )
 
 const namesScript = `
@@ -26,7 +28,7 @@ type NamesRequest struct {
 }
 
 func GetNames(data NamesRequest) ([]string, error) {
-	output, err := runScript(namesTmpl, data)
+	output, err := runScript(context.Background(), namesTmpl, data)
 	if err != nil {
 		return nil, err
 	}
@@ -47,6 +49,24 @@ const targetInfoScript = `
 	in
 	{
 		deployHost = {{ .Config.Hosts.DeployHostAttr }};
+		{{- with .Config.Hosts.DeployUserAttr }}
+		deployUser = {{ . }};
+		{{- end }}
+		{{- with .Config.Hosts.TransportAttr }}
+		transport = {{ . }};
+		{{- end }}
+		{{- with .Config.Hosts.JumpHostAttr }}
+		jumpHost = {{ . }};
+		{{- end }}
+		{{- with .Config.Hosts.ShellAttr }}
+		shell = {{ . }};
+		{{- end }}
+		{{- with .Config.Hosts.HostKeysAttr }}
+		hostKeys = let keys = {{ . }}; in if builtins.isList keys then keys else [ keys ];
+		{{- end }}
+		{{- with .Config.Hosts.TagsAttr }}
+		tags = let tags = {{ . }}; in if builtins.isList tags then tags else [ tags ];
+		{{- end }}
 	}
 `
 
@@ -60,24 +80,21 @@ type TargetInfoRequest struct {
 
 // TargetInfo contains host information queried from nix.  It is cached.
 type TargetInfo struct {
-	DeployHost string `json:"deployHost"`
-	DeployUser string `json:"deployUser"`
+	DeployHost string   `json:"deployHost"`
+	DeployUser string   `json:"deployUser"`
+	Transport  string   `json:"transport"`
+	JumpHost   string   `json:"jumpHost"`
+	Shell      string   `json:"shell"`    // Interprets status scripts.
+	HostKeys   []string `json:"hostKeys"` // Expected SSH host public keys.
+	Tags       []string `json:"tags"`     // Filters configured actions.
+
+	// SSH destination parsed from DeployHost & DeployUser, set once defaults are applied.
+	Destination sshdest.Destination `json:"-"`
 }
 
-func (ti *TargetInfo) SSHDestination() string {
-	user := ti.DeployUser
-
-	dest := "ssh://"
-	if user != "" {
-		dest += user + "@"
-	}
-	dest += ti.DeployHost
-
-	return dest
-}
-
-func GetTargetInfo(data TargetInfoRequest) (*TargetInfo, error) {
-	output, err := runScript(targetInfoTmpl, data)
+// GetTargetInfo evaluates the target info of a host, the evaluation is killed if ctx is done.
+func GetTargetInfo(ctx context.Context, data TargetInfoRequest) (*TargetInfo, error) {
+	output, err := runScript(ctx, targetInfoTmpl, data)
 	if err != nil {
 		return nil, err
 	}
@@ -90,7 +107,7 @@ func GetTargetInfo(data TargetInfoRequest) (*TargetInfo, error) {
 	return &targetInfo, nil
 }
 
-func runScript(tmpl *template.Template, data any) ([]byte, error) {
+func runScript(ctx context.Context, tmpl *template.Template, data any) ([]byte, error) {
 	// Render script.
 	var scriptBuf bytes.Buffer
 	if err := tmpl.Execute(&scriptBuf, data); err != nil {
@@ -103,11 +120,14 @@ func runScript(tmpl *template.Template, data any) ([]byte, error) {
 	slog.Debug("Running nix script", "script", script)
 
 	// Pass script to nix cmd.
-	cmd := exec.Command("nix", "eval", "--file", "-", "--json")
+	cmd := exec.CommandContext(ctx, "nix", "eval", "--file", "-", "--json")
 	cmd.Stdin = bytes.NewReader(script)
 
 	output, err := cmd.Output()
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		output := ""
 		if exit, ok := err.(*exec.ExitError); ok {
 			output = "\n\nOutput:\n"
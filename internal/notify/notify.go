@@ -0,0 +1,118 @@
+// Package notify tells the user a job has finished, via the terminal bell, terminal notification
+// escape sequences, or a local command such as notify-send.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Terminal notification protocols.
+const (
+	OSC9   = "osc9"   // iTerm2, WezTerm, Windows Terminal, etc.
+	OSC777 = "osc777" // urxvt, foot, VTE based terminals, etc.
+)
+
+// Event describes a finished job.
+type Event struct {
+	Host       string
+	Action     string // ie deploy.
+	Command    string
+	State      string // Final state of the runner, ie Failed.
+	Successful bool
+	Duration   time.Duration
+}
+
+// Result returns `success` or `failure`.
+func (e Event) Result() string {
+	if e.Successful {
+		return "success"
+	}
+
+	return "failure"
+}
+
+// Summary describes the event in one line, ie `deploy of web1 succeeded after 2m3s`.
+func (e Event) Summary() string {
+	outcome := "succeeded"
+	if !e.Successful {
+		outcome = "failed (" + e.State + ")"
+	}
+
+	return fmt.Sprintf("%s of %s %s after %s", e.Action, e.Host, outcome, e.Duration.Round(time.Second))
+}
+
+// Env returns the environment describing the event to a notification command.
+func (e Event) Env() []string {
+	return []string{
+		"LABCOAT_HOST=" + e.Host,
+		"LABCOAT_ACTION=" + e.Action,
+		"LABCOAT_COMMAND=" + e.Command,
+		"LABCOAT_RESULT=" + e.Result(),
+		"LABCOAT_STATE=" + e.State,
+		"LABCOAT_DURATION=" + strconv.Itoa(int(e.Duration.Seconds())),
+		"LABCOAT_SUMMARY=" + e.Summary(),
+	}
+}
+
+// Bell rings the terminal bell.
+func Bell(w io.Writer) error {
+	_, err := io.WriteString(w, "\a")
+	return err
+}
+
+// Terminal writes a desktop notification escape sequence for protocol to w.  Terminals that do not
+// support the protocol ignore it.
+func Terminal(w io.Writer, protocol string, title string, body string) error {
+	var seq string
+	switch protocol {
+	case OSC9:
+		seq = "\x1b]9;" + sanitize(body) + "\a"
+	case OSC777:
+		// Fields are separated by semicolons.
+		title = strings.ReplaceAll(sanitize(title), ";", ",")
+		seq = "\x1b]777;notify;" + title + ";" + sanitize(body) + "\a"
+	default:
+		return fmt.Errorf("unknown terminal notification protocol %q", protocol)
+	}
+
+	// Written at once so it cannot be interleaved with screen updates.
+	_, err := io.WriteString(w, seq)
+	return err
+}
+
+// Run executes cmdline with sh, with the event described in its environment.  The full environment
+// of labcoat is passed, as desktop notifiers need DISPLAY, DBUS_SESSION_BUS_ADDRESS, etc.
+func Run(ctx context.Context, cmdline string, e Event) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", cmdline)
+	cmd.Env = append(os.Environ(), e.Env()...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, firstLine(msg))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// sanitize removes control characters, which could terminate the escape sequence.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
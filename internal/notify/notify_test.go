@@ -0,0 +1,71 @@
+package notify_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/labcoat/internal/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var event = notify.Event{
+	Host:       "web1",
+	Action:     "deploy",
+	Command:    "nixos-rebuild switch",
+	State:      "Failed",
+	Successful: false,
+	Duration:   123 * time.Second,
+}
+
+func TestSummary(t *testing.T) {
+	assert.Equal(t, "deploy of web1 failed (Failed) after 2m3s", event.Summary())
+
+	ok := event
+	ok.Successful = true
+	assert.Equal(t, "deploy of web1 succeeded after 2m3s", ok.Summary())
+}
+
+func TestTerminal(t *testing.T) {
+	tcs := map[string]struct {
+		protocol string
+		want     string
+	}{
+		"osc9":   {notify.OSC9, "\x1b]9;body  end\a"},
+		"osc777": {notify.OSC777, "\x1b]777;notify;a,b;body  end\a"},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			var sb strings.Builder
+			require.NoError(t, notify.Terminal(&sb, tc.protocol, "a;b", "body\a\x1bend"))
+			assert.Equal(t, tc.want, sb.String())
+		})
+	}
+
+	var sb strings.Builder
+	assert.Error(t, notify.Terminal(&sb, "bogus", "title", "body"))
+	assert.Empty(t, sb.String())
+}
+
+func TestRun(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "env")
+	err := notify.Run(context.Background(),
+		`printf '%s %s %s %s' "$LABCOAT_HOST" "$LABCOAT_ACTION" "$LABCOAT_RESULT" "$LABCOAT_DURATION" > `+out,
+		event)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "web1 deploy failure 123", string(b))
+}
+
+func TestRunError(t *testing.T) {
+	err := notify.Run(context.Background(), "echo oops >&2; exit 3", event)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "oops")
+}
@@ -0,0 +1,247 @@
+// Package reboot tracks a host through a reboot, from shutdown until the system has booted.
+package reboot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jhillyerd/labcoat/internal/health"
+	"github.com/jhillyerd/labcoat/internal/transport"
+)
+
+// Phase is a stage of a host reboot.
+type Phase int
+
+const (
+	PhaseGoingDown Phase = iota // Host is still up on the previous boot.
+	PhaseDown                   // Host is not reachable.
+	PhasePing                   // Host responds to ping, SSH is not yet available.
+	PhaseSSH                    // SSH is up on the new boot, the system is still starting.
+	PhaseBooted                 // The system has finished booting.
+)
+
+// Phases lists all phases in order.
+var Phases = []Phase{PhaseGoingDown, PhaseDown, PhasePing, PhaseSSH, PhaseBooted}
+
+const (
+	DefaultInterval     = 2 * time.Second
+	DefaultProbeTimeout = 5 * time.Second
+)
+
+const bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// Prints the boot ID, and the systemd state if available.
+const probeCmd = "cat " + bootIDPath + "; systemctl is-system-running 2>/dev/null || true"
+
+// Prints the uptime, and the booted & current system generations.
+const resultCmd = "uptime; readlink /run/booted-system; readlink /run/current-system"
+
+// Status is sent by Tracker.Run when the phase changes, and once tracking has ended.
+type Status struct {
+	Phase   Phase
+	Elapsed time.Duration // Since tracking started.
+	Result  *Result       // Set once booted.
+	Err     error         // Set if tracking failed, ie timed out.
+}
+
+// Final is true if this is the last status sent.
+func (s Status) Final() bool {
+	return s.Result != nil || s.Err != nil
+}
+
+// Result describes the host after it has booted.
+type Result struct {
+	Uptime        string
+	BootedSystem  string // Store path of the booted generation, empty if unknown.
+	CurrentSystem string // Store path of the current generation, empty if unknown.
+}
+
+// GenerationMismatch is true if the host did not boot into its current generation.
+func (r *Result) GenerationMismatch() bool {
+	return r.BootedSystem != "" && r.CurrentSystem != "" && r.BootedSystem != r.CurrentSystem
+}
+
+// Tracker polls a rebooting host via ping and SSH.  Polling stops once the host has booted, or
+// the context is done.
+type Tracker struct {
+	Transport    transport.Transport
+	BootID       string // Boot ID before the reboot, see BootID.
+	PingHost     string // Host name or address to ping, empty to only use SSH.
+	Interval     time.Duration
+	ProbeTimeout time.Duration
+
+	// Ping checks whether host responds to ping, defaults to the ping command.
+	Ping func(ctx context.Context, host string) error
+}
+
+// NewTracker constructs a tracker for the host reached via t, which had bootID before rebooting.
+func NewTracker(t transport.Transport, bootID string, pingHost string) *Tracker {
+	return &Tracker{
+		Transport:    t,
+		BootID:       bootID,
+		PingHost:     pingHost,
+		Interval:     DefaultInterval,
+		ProbeTimeout: DefaultProbeTimeout,
+		Ping:         health.Ping,
+	}
+}
+
+// BootID returns the current boot ID of the host reached via t.
+func BootID(ctx context.Context, t transport.Transport) (string, error) {
+	output, err := run(ctx, t, "cat "+bootIDPath)
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimSpace(output)
+	if id == "" {
+		return "", errors.New("empty boot id")
+	}
+
+	return id, nil
+}
+
+// Run polls the host, sending a Status to updates for each phase change.  The final Status has a
+// Result or Err, after which updates is closed.
+func (t *Tracker) Run(ctx context.Context, updates chan<- Status) {
+	defer close(updates)
+
+	start := time.Now()
+	send := func(s Status) {
+		s.Elapsed = time.Since(start)
+		updates <- s
+	}
+
+	phase := PhaseGoingDown
+	send(Status{Phase: phase})
+
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+
+	for {
+		if next := t.probe(ctx, phase); next != phase {
+			phase = next
+			if phase == PhaseBooted {
+				result, err := t.result(ctx)
+				send(Status{Phase: phase, Result: result, Err: err})
+				return
+			}
+			send(Status{Phase: phase})
+		}
+
+		select {
+		case <-ctx.Done():
+			err := ctx.Err()
+			switch {
+			case errors.Is(err, context.DeadlineExceeded):
+				err = fmt.Errorf("timed out waiting for host, last phase: %s", phase)
+			case errors.Is(err, context.Canceled):
+				err = errors.New("tracking cancelled")
+			}
+			send(Status{Phase: phase, Err: err})
+			return
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe checks the host once, returning the new phase.
+func (t *Tracker) probe(ctx context.Context, phase Phase) Phase {
+	if phase == PhaseDown && t.PingHost != "" && t.Ping != nil {
+		pctx, cancel := context.WithTimeout(ctx, t.ProbeTimeout)
+		err := t.Ping(pctx, t.PingHost)
+		cancel()
+		if err == nil {
+			return PhasePing
+		}
+		// ICMP may be filtered, fall through to SSH.
+	}
+
+	id, state, err := t.probeSSH(ctx)
+	switch {
+	case err != nil:
+		if phase == PhaseGoingDown {
+			return PhaseDown
+		}
+		return phase
+
+	case id == t.BootID:
+		// Not yet rebooted.
+		return phase
+
+	case state == "initializing" || state == "starting":
+		return PhaseSSH
+	}
+
+	// Running, degraded, or not systemd.
+	return PhaseBooted
+}
+
+// probeSSH returns the boot ID and systemd state of the host.
+func (t *Tracker) probeSSH(ctx context.Context) (id string, state string, err error) {
+	pctx, cancel := context.WithTimeout(ctx, t.ProbeTimeout)
+	defer cancel()
+
+	output, err := run(pctx, t.Transport, probeCmd)
+	if err != nil {
+		return "", "", err
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > 1 {
+		state = strings.TrimSpace(lines[1])
+	}
+
+	return strings.TrimSpace(lines[0]), state, nil
+}
+
+func (t *Tracker) result(ctx context.Context) (*Result, error) {
+	pctx, cancel := context.WithTimeout(ctx, t.ProbeTimeout)
+	defer cancel()
+
+	output, err := run(pctx, t.Transport, resultCmd)
+	if err != nil {
+		return nil, fmt.Errorf("host booted, but checking uptime failed: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for len(lines) < 3 {
+		lines = append(lines, "")
+	}
+
+	return &Result{
+		Uptime:        strings.TrimSpace(lines[0]),
+		BootedSystem:  strings.TrimSpace(lines[1]),
+		CurrentSystem: strings.TrimSpace(lines[2]),
+	}, nil
+}
+
+// String returns a short description of the phase.
+func (p Phase) String() string {
+	switch p {
+	case PhaseGoingDown:
+		return "going down"
+	case PhaseDown:
+		return "down"
+	case PhasePing:
+		return "responding to ping"
+	case PhaseSSH:
+		return "SSH up"
+	case PhaseBooted:
+		return "booted"
+	}
+
+	return "unknown"
+}
+
+func run(ctx context.Context, t transport.Transport, cmdline string) (string, error) {
+	var output strings.Builder
+	proc := t.Command(ctx, cmdline)
+	proc.SetStdin(strings.NewReader(""))
+	proc.SetStdout(&output)
+	err := proc.Run()
+
+	return output.String(), err
+}
@@ -0,0 +1,175 @@
+package reboot_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/labcoat/internal/reboot"
+	"github.com/jhillyerd/labcoat/internal/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHost simulates a rebooting host, advancing through steps on each probe.
+type fakeHost struct {
+	mu    sync.Mutex
+	steps []step
+	pings bool // Current ping response.
+}
+
+type step struct {
+	ssh   bool   // SSH is reachable.
+	id    string // Boot ID.
+	state string // systemctl is-system-running output.
+	ping  bool
+}
+
+func (h *fakeHost) transport() *transport.Fake {
+	return &transport.Fake{
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			s := h.steps[0]
+			if len(h.steps) > 1 {
+				h.steps = h.steps[1:]
+			}
+			h.pings = s.ping
+			if !s.ssh {
+				return errors.New("exit status 255")
+			}
+
+			switch req.Cmd {
+			case "cat /proc/sys/kernel/random/boot_id":
+				fmt.Fprintln(req.Stdout, s.id)
+			case "uptime; readlink /run/booted-system; readlink /run/current-system":
+				fmt.Fprintln(req.Stdout, " 10:00:00 up 0 min,  0 users,  load average: 0.50, 0.10, 0.03")
+				fmt.Fprintln(req.Stdout, "/nix/store/aaa-nixos-system")
+				fmt.Fprintln(req.Stdout, "/nix/store/bbb-nixos-system")
+			default:
+				fmt.Fprintln(req.Stdout, s.id)
+				fmt.Fprintln(req.Stdout, s.state)
+			}
+
+			return nil
+		},
+	}
+}
+
+func (h *fakeHost) ping(_ context.Context, _ string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.pings {
+		return nil
+	}
+	return errors.New("no reply")
+}
+
+func track(t *testing.T, h *fakeHost, pingHost string, timeout time.Duration) []reboot.Status {
+	t.Helper()
+
+	tracker := reboot.NewTracker(h.transport(), "old", pingHost)
+	tracker.Interval = time.Millisecond
+	tracker.Ping = h.ping
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	updates := make(chan reboot.Status, 1)
+	go tracker.Run(ctx, updates)
+
+	var got []reboot.Status
+	for s := range updates {
+		got = append(got, s)
+	}
+	require.NotEmpty(t, got)
+
+	return got
+}
+
+func phases(statuses []reboot.Status) []reboot.Phase {
+	var result []reboot.Phase
+	for _, s := range statuses {
+		result = append(result, s.Phase)
+	}
+
+	return result
+}
+
+func TestTrackerPhases(t *testing.T) {
+	h := &fakeHost{steps: []step{
+		{ssh: true, id: "old", state: "stopping"},
+		{ssh: false},
+		{ssh: false},
+		{ssh: false, ping: true}, // Ping is checked after this probe.
+		{ssh: false, ping: true},
+		{ssh: true, id: "new", state: "starting", ping: true},
+		{ssh: true, id: "new", state: "degraded", ping: true},
+		{ssh: true},
+	}}
+
+	got := track(t, h, "host", 5*time.Second)
+	assert.Equal(t, reboot.Phases, phases(got))
+
+	final := got[len(got)-1]
+	require.True(t, final.Final())
+	require.NoError(t, final.Err)
+	require.NotNil(t, final.Result)
+	assert.Contains(t, final.Result.Uptime, "up 0 min")
+	assert.Equal(t, "/nix/store/aaa-nixos-system", final.Result.BootedSystem)
+	assert.True(t, final.Result.GenerationMismatch())
+}
+
+func TestTrackerWithoutPing(t *testing.T) {
+	h := &fakeHost{steps: []step{
+		{ssh: false},
+		{ssh: false, ping: true},
+		{ssh: true, id: "new", state: "running"},
+		{ssh: true},
+	}}
+
+	got := track(t, h, "", 5*time.Second)
+	assert.Equal(t,
+		[]reboot.Phase{reboot.PhaseGoingDown, reboot.PhaseDown, reboot.PhaseBooted},
+		phases(got))
+	assert.NoError(t, got[len(got)-1].Err)
+}
+
+func TestTrackerFastReboot(t *testing.T) {
+	// Host rebooted before the first probe, ie via kexec.
+	h := &fakeHost{steps: []step{
+		{ssh: true, id: "new", state: "running"},
+		{ssh: true},
+	}}
+
+	got := track(t, h, "host", 5*time.Second)
+	assert.Equal(t, []reboot.Phase{reboot.PhaseGoingDown, reboot.PhaseBooted}, phases(got))
+}
+
+func TestTrackerTimeout(t *testing.T) {
+	h := &fakeHost{steps: []step{{ssh: false}}}
+
+	got := track(t, h, "host", 50*time.Millisecond)
+	final := got[len(got)-1]
+	assert.Equal(t, reboot.PhaseDown, final.Phase)
+	require.Error(t, final.Err)
+	assert.Contains(t, final.Err.Error(), "timed out")
+	assert.Nil(t, final.Result)
+}
+
+func TestBootID(t *testing.T) {
+	h := &fakeHost{steps: []step{{ssh: true, id: "abc"}}}
+
+	id, err := reboot.BootID(context.Background(), h.transport())
+	require.NoError(t, err)
+	assert.Equal(t, "abc", id)
+
+	h = &fakeHost{steps: []step{{ssh: false}}}
+	_, err = reboot.BootID(context.Background(), h.transport())
+	assert.Error(t, err)
+}
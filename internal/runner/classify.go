@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"regexp"
+)
+
+// Failure is a recognized cause of a failed command.
+type Failure int
+
+const (
+	FailureUnknown Failure = iota
+	FailureDNS
+	FailureConnect
+	FailureAuth
+	FailureHostKey
+	FailureMissingBinary
+	FailureSudo
+)
+
+// Only the tail of the output is searched, connection errors are printed just before exit.
+const classifyTailBytes = 8 * 1024
+
+// Patterns matched against the output & error of failed commands, in priority order.  Covers
+// OpenSSH, golang.org/x/crypto/ssh, and common shells.
+var failurePatterns = []struct {
+	failure Failure
+	re      *regexp.Regexp
+}{
+	{FailureHostKey, regexp.MustCompile(
+		`(?i)REMOTE HOST IDENTIFICATION HAS CHANGED|Host key verification failed|` +
+			`knownhosts: key (mismatch|is unknown)|No .* host key is known for`)},
+	{FailureDNS, regexp.MustCompile(
+		`(?i)Could not resolve hostname|Name or service not known|no such host|` +
+			`Temporary failure in name resolution|nodename nor servname provided`)},
+	{FailureConnect, regexp.MustCompile(
+		`(?i)Connection refused|Connection timed out|Operation timed out|No route to host|` +
+			`Network is unreachable|i/o timeout|Connection closed by .* port|` +
+			`Connection reset by peer`)},
+	{FailureAuth, regexp.MustCompile(
+		`(?i)Permission denied \(|Permission denied, please try again|` +
+			`Too many authentication failures|unable to authenticate|no supported methods remain`)},
+	{FailureSudo, regexp.MustCompile(
+		`(?i)sudo: \d+ incorrect password attempts?|sudo: a password is required|` +
+			`sudo: no password was provided|sudo: a terminal is required|is not in the sudoers file|` +
+			`sudo: no askpass program`)},
+	{FailureMissingBinary, regexp.MustCompile(
+		`(?im)command not found|: not found$|exit status 127|exited with status 127`)},
+}
+
+// Matched against the output & error of remote commands that lost their connection, ie because the
+// host was rebooted.  Exit status 143 is from the SIGTERM sent to processes during shutdown.
+var disconnectPattern = regexp.MustCompile(
+	`(?i)closed by remote host|Shared connection to .* closed|exited without exit status|` +
+		`Connection reset by peer|broken pipe|exit status 143`)
+
+// Classify attempts to recognize the cause of a failed command from its output and error.
+func Classify(output string, err error) Failure {
+	output = classifyText(output, err)
+	for _, p := range failurePatterns {
+		if p.re.MatchString(output) {
+			return p.failure
+		}
+	}
+
+	return FailureUnknown
+}
+
+// Connection is true if the failure is in connecting to a host, rather than of the command.
+func (f Failure) Connection() bool {
+	switch f {
+	case FailureDNS, FailureConnect, FailureAuth, FailureHostKey:
+		return true
+	}
+
+	return false
+}
+
+// Disconnected is true if a failed remote command appears to have lost its connection to the host
+// after it started.
+func Disconnected(output string, err error) bool {
+	switch Classify(output, err) {
+	case FailureDNS, FailureAuth, FailureHostKey, FailureSudo, FailureMissingBinary:
+		return false
+	}
+
+	return disconnectPattern.MatchString(classifyText(output, err))
+}
+
+// classifyText returns the tail of output followed by err.
+func classifyText(output string, err error) string {
+	if len(output) > classifyTailBytes {
+		output = output[len(output)-classifyTailBytes:]
+	}
+	if err != nil {
+		output += "\n" + err.Error()
+	}
+
+	return output
+}
+
+// String returns a short description of the failure.
+func (f Failure) String() string {
+	switch f {
+	case FailureDNS:
+		return "Host name lookup failed"
+	case FailureConnect:
+		return "Could not connect to host"
+	case FailureAuth:
+		return "Authentication failed"
+	case FailureHostKey:
+		return "Host key verification failed"
+	case FailureMissingBinary:
+		return "Command not found on host"
+	case FailureSudo:
+		return "Sudo authentication failed"
+	}
+
+	return "Unknown failure"
+}
+
+// Hint returns an actionable message for the failure, or an empty string if unknown.
+func (f Failure) Hint() string {
+	switch f {
+	case FailureDNS:
+		return "Check the deploy host name and default-ssh-domain, and that DNS is reachable."
+	case FailureConnect:
+		return "Check that the host is up and that sshd is listening and not firewalled."
+	case FailureAuth:
+		return "Check the deploy user, and that your key is loaded in ssh-agent or configured " +
+			"in ~/.ssh/config."
+	case FailureHostKey:
+		return "The host key is unknown or has changed; verify the host before updating " +
+			"known_hosts."
+	case FailureMissingBinary:
+		return "A required program is missing on the host; check the command and the host PATH."
+	case FailureSudo:
+		return "Check the sudo password, and that the deploy user is permitted to use sudo."
+	}
+
+	return ""
+}
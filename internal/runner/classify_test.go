@@ -0,0 +1,155 @@
+package runner
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	tcs := []struct {
+		name   string
+		output string
+		err    error
+		want   Failure
+	}{
+		{
+			name:   "openssh dns",
+			output: "ssh: Could not resolve hostname nope.example.com: Name or service not known\r\n",
+			err:    errors.New("exit status 255"),
+			want:   FailureDNS,
+		},
+		{
+			name: "native dns",
+			err:  errors.New("dial tcp: lookup nope.example.com on 127.0.0.53:53: no such host"),
+			want: FailureDNS,
+		},
+		{
+			name:   "refused",
+			output: "ssh: connect to host 10.0.0.1 port 22: Connection refused\r\n",
+			err:    errors.New("exit status 255"),
+			want:   FailureConnect,
+		},
+		{
+			name: "native timeout",
+			err:  errors.New("dial tcp 10.0.0.1:22: i/o timeout"),
+			want: FailureConnect,
+		},
+		{
+			name:   "openssh auth",
+			output: "root@host: Permission denied (publickey,keyboard-interactive).\r\n",
+			err:    errors.New("exit status 255"),
+			want:   FailureAuth,
+		},
+		{
+			name: "native auth",
+			err: errors.New("ssh: handshake failed: ssh: unable to authenticate, " +
+				"attempted methods [none publickey], no supported methods remain"),
+			want: FailureAuth,
+		},
+		{
+			name: "openssh host key changed",
+			output: "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n" +
+				"@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\n" +
+				"Host key verification failed.\n",
+			err:  errors.New("exit status 255"),
+			want: FailureHostKey,
+		},
+		{
+			name: "native host key",
+			err:  errors.New("ssh: handshake failed: knownhosts: key mismatch"),
+			want: FailureHostKey,
+		},
+		{
+			name:   "missing bash",
+			output: "sh: 1: bash: not found\n",
+			err:    errors.New("exit status 127"),
+			want:   FailureMissingBinary,
+		},
+		{
+			name:   "missing command",
+			output: "bash: line 1: htop: command not found\n",
+			err:    errors.New("Process exited with status 127"),
+			want:   FailureMissingBinary,
+		},
+		{
+			name:   "plain failure",
+			output: "error: builder for '/nix/store/xyz.drv' failed with exit code 1\n",
+			err:    errors.New("exit status 1"),
+			want:   FailureUnknown,
+		},
+		{
+			name:   "only tail searched",
+			output: "Connection refused\n" + strings.Repeat("x", classifyTailBytes),
+			err:    errors.New("exit status 1"),
+			want:   FailureUnknown,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Classify(tc.output, tc.err)
+			assert.Equal(t, tc.want, got, "got %v", got)
+			if tc.want != FailureUnknown {
+				assert.NotEmpty(t, got.Hint())
+			}
+		})
+	}
+}
+
+func TestDisconnected(t *testing.T) {
+	tcs := []struct {
+		name   string
+		output string
+		err    error
+		want   bool
+	}{
+		{
+			name:   "openssh",
+			output: "Connection to web.example.com closed by remote host.\r\n",
+			err:    errors.New("exit status 255"),
+			want:   true,
+		},
+		{
+			name:   "control master",
+			output: "Shared connection to web.example.com closed.\r\n",
+			err:    errors.New("exit status 255"),
+			want:   true,
+		},
+		{
+			name: "native",
+			err:  errors.New("wait: remote command exited without exit status or exit signal"),
+			want: true,
+		},
+		{
+			name: "terminated",
+			err:  errors.New("exit status 143"),
+			want: true,
+		},
+		{
+			name:   "never connected",
+			output: "ssh: Could not resolve hostname nope: Name or service not known\r\n",
+			err:    errors.New("exit status 255"),
+			want:   false,
+		},
+		{
+			name:   "auth",
+			output: "root@web: Permission denied (publickey).\r\n",
+			err:    errors.New("exit status 255"),
+			want:   false,
+		},
+		{
+			name: "plain failure",
+			err:  errors.New("exit status 1"),
+			want: false,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, Disconnected(tc.output, tc.err))
+		})
+	}
+}
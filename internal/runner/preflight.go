@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jhillyerd/labcoat/internal/transport"
+)
+
+// PreflightCheck is the result of a single preflight check.
+type PreflightCheck struct {
+	Name    string
+	Passed  bool
+	Skipped bool   // Not run because an earlier check failed.
+	Output  string // Command output on failure.
+	Failure Failure
+}
+
+// Preflight tests connectivity, authentication and optionally sudo on the target host of the
+// provided transport, returning the checks and whether all passed.  Checks are skipped once one
+// fails.
+func Preflight(ctx context.Context, t transport.Transport, sudo *Sudo) ([]PreflightCheck, bool) {
+	connect := PreflightCheck{Name: "connectivity"}
+	auth := PreflightCheck{Name: "authentication"}
+	checks := []*PreflightCheck{&connect, &auth}
+
+	// A single command exercises both connectivity and auth; the failure type tells us which
+	// failed.
+	output, err := runCheck(ctx, t, "", "true")
+	if err == nil {
+		connect.Passed = true
+		auth.Passed = true
+	} else {
+		failure := Classify(output, err)
+		switch failure {
+		case FailureDNS, FailureConnect:
+			connect.Output, connect.Failure = output, failure
+			auth.Skipped = true
+		default:
+			connect.Passed = true
+			auth.Output, auth.Failure = output, failure
+		}
+	}
+
+	if sudo != nil {
+		check := PreflightCheck{Name: "sudo", Skipped: !auth.Passed}
+		if auth.Passed {
+			output, err := runCheck(ctx, t, sudo.Input(), sudo.Wrap("true"))
+			check.Passed = err == nil
+			if err != nil {
+				check.Output, check.Failure = output, Classify(output, err)
+			}
+		}
+		checks = append(checks, &check)
+	}
+
+	ok := true
+	result := make([]PreflightCheck, 0, len(checks))
+	for _, c := range checks {
+		ok = ok && c.Passed
+		result = append(result, *c)
+	}
+
+	return result, ok
+}
+
+func runCheck(
+	ctx context.Context, t transport.Transport, stdin string, prog string, args ...string,
+) (string, error) {
+	var output strings.Builder
+	proc := t.Command(ctx, prog, args...)
+	proc.SetStdin(strings.NewReader(stdin))
+	proc.SetStdout(&output)
+	proc.SetStderr(&output)
+	err := proc.Run()
+
+	return strings.TrimSpace(output.String()), err
+}
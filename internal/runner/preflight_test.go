@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jhillyerd/labcoat/internal/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreflight(t *testing.T) {
+	tcs := []struct {
+		name    string
+		sudo    *Sudo
+		stderr  map[string]string // Keyed by command, others succeed.
+		want    []string          // Check names with state suffix.
+		wantOK  bool
+		failure Failure
+	}{
+		{
+			name:   "all pass",
+			sudo:   &Sudo{},
+			want:   []string{"connectivity:pass", "authentication:pass", "sudo:pass"},
+			wantOK: true,
+		},
+		{
+			name:   "no sudo check",
+			want:   []string{"connectivity:pass", "authentication:pass"},
+			wantOK: true,
+		},
+		{
+			name:    "unreachable",
+			sudo:    &Sudo{},
+			stderr:  map[string]string{"true": "ssh: connect to host h port 22: Connection refused"},
+			want:    []string{"connectivity:fail", "authentication:skip", "sudo:skip"},
+			failure: FailureConnect,
+		},
+		{
+			name:    "auth",
+			stderr:  map[string]string{"true": "deploy@h: Permission denied (publickey)."},
+			want:    []string{"connectivity:pass", "authentication:fail"},
+			failure: FailureAuth,
+		},
+		{
+			name:    "sudo password",
+			sudo:    &Sudo{},
+			stderr:  map[string]string{(&Sudo{}).Wrap("true"): "sudo: a password is required"},
+			want:    []string{"connectivity:pass", "authentication:pass", "sudo:fail"},
+			failure: FailureSudo,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := &transport.Fake{
+				Handler: func(_ context.Context, req transport.FakeRequest) error {
+					if msg, ok := tc.stderr[req.Cmd]; ok {
+						fmt.Fprintln(req.Stderr, msg)
+						return errors.New("exit status 1")
+					}
+					return nil
+				},
+			}
+
+			checks, ok := Preflight(context.Background(), fake, tc.sudo)
+			assert.Equal(t, tc.wantOK, ok)
+
+			got := make([]string, 0, len(checks))
+			for _, c := range checks {
+				state := "fail"
+				if c.Passed {
+					state = "pass"
+				} else if c.Skipped {
+					state = "skip"
+				} else {
+					require.NotEmpty(t, c.Output)
+					assert.Equal(t, tc.failure, c.Failure)
+				}
+				got = append(got, c.Name+":"+state)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jhillyerd/labcoat/internal/transport"
+)
+
+// Bounds the entire kill sequence, each signal is sent by a new transport command.
+const remoteKillTimeout = 15 * time.Second
+
+var errKillUnreachable = errors.New("remote kill: host unreachable")
+
+// remoteKill tracks the process group of a remote command, so that it can be signaled via a
+// second transport command.  Killing the local ssh client does not stop the remote process.
+type remoteKill struct {
+	t       transport.Transport
+	pidFile string // Remote path, expanded by the remote shell.
+	sudo    *Sudo  // Elevates the kill, as the process group of an elevated command is owned by root.
+
+	mu          sync.Mutex
+	deadline    time.Time // Set by the first signal.
+	unreachable bool      // A kill command failed to connect, later signals are not attempted.
+}
+
+func newRemoteKill(t transport.Transport) (*remoteKill, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteKill{
+		t:       t,
+		pidFile: `"${TMPDIR:-/tmp}/labcoat-` + nonce + `.pid"`,
+	}, nil
+}
+
+// wrap returns a remote command line that runs cmdline in a new session, recording the session
+// process group ID in the pid file for the duration of the command.
+func (k *remoteKill) wrap(cmdline string) string {
+	const script = `echo $$ >"$0"; sh -c "$1"; rc=$?; rm -f "$0"; exit $rc`
+	run := fmt.Sprintf("sh -c %s %s %s", singleQuote(script), k.pidFile, singleQuote(cmdline))
+
+	// Fall back to running without a new session when setsid is missing or does not support -w,
+	// ie busybox; signals then go to the wrapper's children.
+	return transport.ShCmdline(
+		"if setsid -w true 2>/dev/null; then exec setsid -w " + run + "; else exec " + run + "; fi")
+}
+
+// scriptPrologue returns script lines that record the PID of the script shell in the pid file.
+// Scripts do not run in their own session, so children are signaled via their parent PID.
+func (k *remoteKill) scriptPrologue() string {
+	return fmt.Sprintf("echo $$ >%s; _lc_tmp=\"$_lc_tmp \"%s; %s\n", k.pidFile, k.pidFile, cleanupTrap)
+}
+
+// signal delivers sig to the remote process group, returning once the kill command exits.
+func (k *remoteKill) signal(sig syscall.Signal) error {
+	name := strings.TrimPrefix(signalName(sig), "SIG")
+	// Without a process group, signal each descendant of the recorded process, deepest first.
+	kill := fmt.Sprintf(`kill -s %s -- "-$1" 2>/dev/null || { _lc_kill() { `+
+		`for c in $(pgrep -P "$1"); do _lc_kill "$c"; done; kill -s %s "$1"; }; _lc_kill "$1"; }`,
+		name, name)
+	run := "sh -c " + singleQuote(kill) + ` _ "$pg"`
+
+	// The pid file is read before elevating, sudo may reset $TMPDIR.
+	prologue, input := "", ""
+	if k.sudo != nil {
+		run = k.sudo.prefix() + " " + run
+		if input = k.sudo.Input(); input != "" {
+			prologue = sudoPrologue + "; "
+		}
+	}
+	cmdline := fmt.Sprintf(`%sf=%s; [ -f "$f" ] && pg=$(cat "$f") && %s`, prologue, k.pidFile, run)
+
+	k.mu.Lock()
+	if k.unreachable {
+		k.mu.Unlock()
+		return errKillUnreachable
+	}
+	if k.deadline.IsZero() {
+		k.deadline = time.Now().Add(remoteKillTimeout)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), k.deadline)
+	k.mu.Unlock()
+	defer cancel()
+
+	var output strings.Builder
+	proc := k.t.Command(ctx, transport.ShCmdline(cmdline))
+	if input != "" {
+		proc.SetStdin(strings.NewReader(input))
+	}
+	proc.SetStdout(&output)
+	proc.SetStderr(&output)
+	if err := proc.Run(); err != nil {
+		if transport.ConnectionFailed(err) {
+			k.mu.Lock()
+			k.unreachable = true
+			k.mu.Unlock()
+		}
+		slog.Debug("Remote kill failed", "signal", name, "err", err, "output", output.String())
+		return err
+	}
+
+	return nil
+}
+
+func signalName(sig syscall.Signal) string {
+	switch sig {
+	case syscall.SIGINT:
+		return "SIGINT"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	case syscall.SIGKILL:
+		return "SIGKILL"
+	}
+
+	return sig.String()
+}
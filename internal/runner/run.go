@@ -3,15 +3,18 @@ package runner
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"log/slog"
 	"os"
-	"os/exec"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/jhillyerd/labcoat/internal/transport"
 )
 
 const (
@@ -19,8 +22,20 @@ const (
 	stateRunning
 	stateDone
 	stateFailed
+	stateTimedOut
 )
 
+// Cancellation stages, each escalates to the next after the grace period.
+const (
+	cancelNone = iota
+	cancelInterrupt
+	cancelTerminate
+	cancelKill
+)
+
+// DefaultCancelGrace is how long a cancelled process has to exit before the next signal is sent.
+const DefaultCancelGrace = 5 * time.Second
+
 // Model runner runs commands and collects their output.
 type Model struct {
 	sync.RWMutex
@@ -31,27 +46,46 @@ type Model struct {
 
 	prog   string
 	args   []string
+	label  string // Overrides the command line for display.
 	dest   string
-	cmd    *exec.Cmd
+	proc   transport.Process
 	state  int
 	err    error
 	closed bool // No more writes accepted when true.
 
+	input    chan string          // Queued for the stdin pipe writer when input is enabled.
+	stdinR   *os.File             // Read end of stdin pipe, owned by the process.
 	output   *buffer              // Permanent output buffer.
 	onUpdate func(*Model) tea.Msg // Construct msg when there is new output.
 	notify   chan struct{}        // Pinged when data is written to buffer.
 	cancel   func()
+
+	killer      *remoteKill // Signals the remote process group, nil for local processes.
+	cancelStage int
+	cancelGrace time.Duration
+	escalate    *time.Timer
+
+	timeout  time.Duration // Zero for no timeout.
+	deadline *time.Timer
+	timedOut bool
+	failure  Failure // Recognized cause of failure.
+
+	disconnectOK bool // Losing the connection is not a failure.
+	disconnected bool // Completed when the connection was lost.
+
+	started  time.Time
+	finished time.Time
 }
 
 // NewLocal constructs a runner for a local command.
 func NewLocal(ctx context.Context, onUpdate func(*Model) tea.Msg, dir string, prog string, args ...string) *Model {
 	ctx, cancel := context.WithCancel(ctx)
 
+	proc := transport.NewExecProcess(ctx, prog, args...)
+	proc.Dir = dir
+
 	r := newRunner(onUpdate, prog, args...)
-	r.cmd = exec.CommandContext(ctx, prog, args...)
-	r.cmd.Dir = dir
-	r.cmd.Stdout = r.output
-	r.cmd.Stderr = r.output
+	r.setProcess(proc)
 	r.cancel = cancel
 	r.dest = "local"
 
@@ -60,65 +94,69 @@ func NewLocal(ctx context.Context, onUpdate func(*Model) tea.Msg, dir string, pr
 	return r
 }
 
+// NewRemote constructs a runner for a command on the target host of the provided transport.
 func NewRemote(
-	ctx context.Context, onUpdate func(*Model) tea.Msg, host string, user string,
+	ctx context.Context, onUpdate func(*Model) tea.Msg, t transport.Transport,
 	prog string, args ...string,
-) *Model {
-	ctx, cancel := context.WithCancel(ctx)
-
-	dest := "ssh://"
-	if user != "" {
-		dest += user + "@"
+) (*Model, error) {
+	killer, err := newRemoteKill(t)
+	if err != nil {
+		return nil, err
 	}
-	dest += host
-
-	sshArgs := []string{"-T", "-oBatchMode=yes", dest, prog}
-	sshArgs = append(sshArgs, args...)
+	ctx, cancel := context.WithCancel(ctx)
 
 	r := newRunner(onUpdate, prog, args...)
-	r.cmd = exec.CommandContext(ctx, "ssh", sshArgs...)
-	r.cmd.Stdout = r.output
-	r.cmd.Stderr = r.output
+	r.killer = killer
+	r.setProcess(t.Command(ctx, r.killer.wrap(strings.Join(append([]string{prog}, args...), " "))))
 	r.cancel = cancel
-	r.dest = dest
+	r.dest = t.Destination()
 
-	slog.Debug("Remote runner created", "prog", prog, "args", args, "dest", dest)
+	slog.Debug("Remote runner created", "prog", prog, "args", args, "dest", r.dest)
 
-	return r
+	return r, nil
 }
 
+// NewRemoteScript constructs a runner for a sh script on the target host of the provided
+// transport, interpreted by shell if it is installed.  A non-empty secret, ie a sudo password, is
+// passed to the script as $_lc_secret.
 func NewRemoteScript(
-	ctx context.Context, onUpdate func(*Model) tea.Msg, host string, user string,
-	name string, script string,
-) *Model {
+	ctx context.Context, onUpdate func(*Model) tea.Msg, t transport.Transport, shell string,
+	name string, secret string, script string,
+) (*Model, error) {
+	killer, err := newRemoteKill(t)
+	if err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithCancel(ctx)
 
-	dest := "ssh://"
-	if user != "" {
-		dest += user + "@"
+	r := newRunner(onUpdate, name)
+	r.killer = killer
+
+	stdin := r.killer.scriptPrologue() + script
+	if secret != "" {
+		stdin = secret + "\n" + stdin
 	}
-	dest += host
+	proc := t.Script(ctx, shell, secret != "")
+	proc.SetStdin(strings.NewReader(stdin))
 
-	r := newRunner(onUpdate, name)
-	r.cmd = exec.CommandContext(ctx, "ssh", "-T", "-oBatchMode=yes", dest, "bash", "-s")
-	r.cmd.Stdin = strings.NewReader(script)
-	r.cmd.Stdout = r.output
-	r.cmd.Stderr = r.output
+	r.setProcess(proc)
 	r.cancel = cancel
-	r.dest = dest
+	r.dest = t.Destination()
 
-	slog.Debug("Remote runner created", "script", name, "dest", dest)
+	slog.Debug("Remote runner created", "script", name, "dest", r.dest)
 
-	return r
+	return r, nil
 }
 
-// newRunner creates a basic Model, which further requires `cmd` and `dest` to be populated.
+// newRunner creates a basic Model, which further requires `proc` and `dest` to be populated.
 func newRunner(onUpdate func(*Model) tea.Msg, prog string, args ...string) *Model {
 	r := &Model{
 		prog:     prog,
 		args:     args,
 		onUpdate: onUpdate,
 		notify:   make(chan struct{}, 1),
+
+		cancelGrace: DefaultCancelGrace,
 	}
 
 	r.output = newBuffer(func() {
@@ -140,11 +178,15 @@ func (r *Model) Running() bool {
 	return r.state == stateNotStarted || r.state == stateRunning
 }
 
-// Complete is true if in done or failed state.
+// Complete is true if in done, failed, or timed out state.
 func (r *Model) Complete() bool {
 	r.RLock()
 	defer r.RUnlock()
-	return r.state == stateDone || r.state == stateFailed
+	return r.complete()
+}
+
+func (r *Model) complete() bool {
+	return r.state == stateDone || r.state == stateFailed || r.state == stateTimedOut
 }
 
 // Successful is true if done, not failed.
@@ -154,6 +196,20 @@ func (r *Model) Successful() bool {
 	return r.state == stateDone
 }
 
+// Failure returns the recognized cause of failure, available once complete.
+func (r *Model) Failure() Failure {
+	r.RLock()
+	defer r.RUnlock()
+	return r.failure
+}
+
+// TimedOut is true if the process was cancelled because it exceeded its timeout.
+func (r *Model) TimedOut() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.state == stateTimedOut
+}
+
 func (r *Model) waitForOutput() tea.Cmd {
 	return func() tea.Msg {
 		if r.Complete() {
@@ -165,7 +221,14 @@ func (r *Model) waitForOutput() tea.Cmd {
 
 			// Render status text and stop waiting for output.
 			r.closed = true
-			s := r.Styles.StatusSuffix.Render("\n[" + stateToString(r.state) + "]")
+			status := stateToString(r.state)
+			if r.disconnected {
+				status += ": Connection closed by host"
+			}
+			if r.failure != FailureUnknown {
+				status += ": " + r.failure.String() + "]\n[" + r.failure.Hint()
+			}
+			s := r.Styles.StatusSuffix.Render("\n[" + status + "]")
 			_, _ = r.output.Write([]byte(s))
 
 			return nil
@@ -181,17 +244,39 @@ func (r *Model) Init() tea.Cmd {
 	cmd := func() tea.Msg {
 		r.Lock()
 		r.state = stateRunning
+		r.started = time.Now()
+		if r.timeout > 0 {
+			r.deadline = time.AfterFunc(r.timeout, r.expire)
+		}
 		r.Unlock()
 
 		slog.Info("running", "cmd", r, "dest", r.dest)
 
-		r.err = r.cmd.Run()
+		err := r.proc.Run()
 
 		r.Lock()
-		if r.err == nil {
+		r.err = err
+		r.finished = time.Now()
+		r.closeStdin()
+		if r.escalate != nil {
+			r.escalate.Stop()
+		}
+		if r.deadline != nil {
+			r.deadline.Stop()
+		}
+		switch {
+		case r.err == nil:
+			r.state = stateDone
+		case r.timedOut:
+			r.state = stateTimedOut
+		case r.disconnectOK && r.cancelStage == cancelNone && Disconnected(r.output.String(), r.err):
 			r.state = stateDone
-		} else {
+			r.disconnected = true
+		default:
 			r.state = stateFailed
+			if r.cancelStage == cancelNone {
+				r.failure = r.classify()
+			}
 		}
 		r.Unlock()
 
@@ -201,18 +286,214 @@ func (r *Model) Init() tea.Cmd {
 	return tea.Batch(cmd, r.waitForOutput())
 }
 
-// PassEnv copies a parent environment variable for use by the child process.
+// Started returns when the process was started, zero if it has not been.
+func (r *Model) Started() time.Time {
+	r.RLock()
+	defer r.RUnlock()
+	return r.started
+}
+
+// Finished returns when the process exited, zero if it has not.
+func (r *Model) Finished() time.Time {
+	r.RLock()
+	defer r.RUnlock()
+	return r.finished
+}
+
+// ExitCode returns the exit code of the process once complete, or -1 if it did not exit normally,
+// ie it was killed or the connection was lost.
+func (r *Model) ExitCode() int {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.err == nil {
+		if r.complete() {
+			return 0
+		}
+		return -1
+	}
+
+	var coder interface{ ExitCode() int }
+	if errors.As(r.err, &coder) {
+		return coder.ExitCode()
+	}
+	// golang.org/x/crypto/ssh.ExitError.
+	var status interface{ ExitStatus() int }
+	if errors.As(r.err, &status) {
+		return status.ExitStatus()
+	}
+
+	return -1
+}
+
+// SetDisconnectOK treats loss of the connection to the host as success, ie for a reboot command.
+// Must be called before Init.
+func (r *Model) SetDisconnectOK() {
+	r.Lock()
+	defer r.Unlock()
+	r.disconnectOK = true
+}
+
+// SetSudo signals the remote process via sudo, as required when its command line was wrapped by
+// s.  Must be called before Init.
+func (r *Model) SetSudo(s *Sudo) {
+	r.Lock()
+	defer r.Unlock()
+	if r.killer != nil {
+		r.killer.sudo = s
+	}
+}
+
+// SetTimeout limits how long the process may run before it is cancelled, zero disables the
+// timeout.  Must be called before Init.
+func (r *Model) SetTimeout(d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.timeout = d
+}
+
+// expire cancels the process when the timeout is reached.
+func (r *Model) expire() {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.state != stateRunning || r.cancelStage != cancelNone {
+		// Finished, or already being cancelled by the user.
+		return
+	}
+
+	r.timedOut = true
+	_, _ = r.output.Write([]byte("\n[Timed out after " + r.timeout.String() + "]\n"))
+	slog.Warn("Runner timed out", "cmd", r, "dest", r.dest, "timeout", r.timeout)
+	r.advanceCancel()
+}
+
+// Lines of input queued for the process before WriteInput fails, rather than blocking the caller.
+const inputQueueLen = 64
+
+// SetInput provides s as the entire stdin of the process, ie a password preamble.  Commands that
+// read further input see EOF.  Must be called before Init.
+func (r *Model) SetInput(s string) {
+	r.Lock()
+	defer r.Unlock()
+	r.proc.SetStdin(strings.NewReader(s))
+}
+
+// EnableInput connects a pipe to the process stdin, lines may then be sent with WriteInput.  Reads
+// by the process block until input is written or closed, so input should only be enabled at the
+// request of the user.  Must be called before Init.
+func (r *Model) EnableInput() error {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.proc.SetStdin(pr)
+	r.input = make(chan string, inputQueueLen)
+	r.stdinR = pr
+
+	// Writes block while the pipe is full, so are made on behalf of the caller.
+	go func(input <-chan string) {
+		defer pw.Close()
+		for s := range input {
+			if _, err := pw.WriteString(s); err != nil {
+				slog.Debug("Failed to write runner input", "cmd", r, "err", err)
+			}
+		}
+	}(r.input)
+
+	return nil
+}
+
+// InputEnabled is true if the process stdin is open for writing.
+func (r *Model) InputEnabled() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.input != nil
+}
+
+// WriteInput queues s to be written to the process stdin, without blocking.
+func (r *Model) WriteInput(s string) error {
+	r.RLock()
+	defer r.RUnlock()
+	if r.input == nil {
+		return errors.New("input not enabled")
+	}
+
+	select {
+	case r.input <- s:
+		return nil
+	default:
+		return errors.New("input not being read")
+	}
+}
+
+// CloseInput closes the process stdin once queued input is written, signaling end of input.
+func (r *Model) CloseInput() {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.input != nil {
+		close(r.input)
+		r.input = nil
+	}
+}
+
+// closeStdin stops accepting input, and closes the read end of the stdin pipe once the process has
+// exited, failing any blocked write.  Caller must hold the write lock.
+func (r *Model) closeStdin() {
+	if r.input != nil {
+		close(r.input)
+		r.input = nil
+	}
+	if r.stdinR != nil {
+		_ = r.stdinR.Close()
+		r.stdinR = nil
+	}
+}
+
+// PassEnv copies a parent environment variable for use by the child process, if it is set.
 func (r *Model) PassEnv(name string) {
-	value := os.Getenv(name)
-	r.SetEnv(name, value)
+	if value, ok := os.LookupEnv(name); ok {
+		r.SetEnv(name, value)
+	}
 }
 
 // SetEnv appends an environment variable definition.  Due to the way `exec.Cmd` works, the first
 // call to this effectively stops the parent environment from being passed to the child process.
+// Only supported by local runners.
 func (r *Model) SetEnv(name string, value string) {
 	r.Lock()
 	defer r.Unlock()
-	r.cmd.Env = append(r.cmd.Env, name+"="+value)
+
+	proc, ok := r.proc.(*transport.ExecProcess)
+	if !ok {
+		slog.Error("SetEnv called on runner without local process (bug)", "cmd", r)
+		return
+	}
+	proc.SetEnv(name, value)
+}
+
+// Env returns the environment definitions of a local runner, nil if the parent environment is
+// inherited or the runner is remote.
+func (r *Model) Env() []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	if proc, ok := r.proc.(*transport.ExecProcess); ok {
+		return append([]string(nil), proc.Env...)
+	}
+
+	return nil
+}
+
+// setProcess connects the process output to the runner buffer.
+func (r *Model) setProcess(proc transport.Process) {
+	proc.SetStdout(r.output)
+	proc.SetStderr(r.output)
+	r.proc = proc
 }
 
 // Update implements tea.Model.
@@ -237,15 +518,122 @@ func (r *Model) CopyTo(w io.Writer) (int64, error) {
 	return io.Copy(w, br)
 }
 
-// Cancel running process.
+// CopyFrom writes buffer contents following the first offset bytes to the provided writer, so that
+// output may be consumed incrementally.
+func (r *Model) CopyFrom(w io.Writer, offset int64) (int64, error) {
+	r.output.RLock()
+	defer r.output.RUnlock()
+
+	br := bytes.NewReader(r.output.buf)
+	if _, err := br.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, br)
+}
+
+// Cancel running process.  The first call interrupts the process with SIGINT, after which it is
+// sent SIGTERM and finally SIGKILL if it fails to exit within the grace period.  Repeated calls
+// escalate immediately.
 func (r *Model) Cancel() {
-	if r.cancel != nil {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.cancel == nil || r.complete() {
+		return
+	}
+
+	r.advanceCancel()
+}
+
+// advanceCancel moves to the next cancellation stage.  Caller must hold the write lock.
+func (r *Model) advanceCancel() {
+	if r.cancelStage == cancelKill {
+		return
+	}
+	if r.escalate != nil {
+		r.escalate.Stop()
+	}
+
+	r.cancelStage++
+	sig := cancelSignal(r.cancelStage)
+	_, _ = r.output.Write([]byte("\n[" + cancelStageString(r.cancelStage) + "]\n"))
+	slog.Info("Cancelling", "cmd", r, "dest", r.dest, "signal", signalName(sig))
+
+	if r.state == stateNotStarted {
+		// Nothing to signal yet, prevent the process from starting.
+		r.cancelStage = cancelKill
 		r.cancel()
+		return
+	}
 
-		r.Lock()
-		defer r.Unlock()
-		_, _ = r.output.Write([]byte("\n[Interrupt]\n"))
+	go r.sendSignal(sig)
+
+	if r.cancelStage < cancelKill {
+		r.escalate = time.AfterFunc(r.cancelGrace, func() {
+			r.Lock()
+			defer r.Unlock()
+			if r.state == stateRunning {
+				r.advanceCancel()
+			}
+		})
+	}
+}
+
+// sendSignal delivers sig to the process.  Remote processes are signaled via their process group,
+// as killing the local ssh client would leave them running.  SIGKILL also cancels the context.
+func (r *Model) sendSignal(sig syscall.Signal) {
+	delivered := false
+	if r.killer != nil {
+		delivered = r.killer.signal(sig) == nil
+	}
+	if !delivered && sig != syscall.SIGKILL {
+		if err := r.proc.Signal(sig); err != nil {
+			slog.Debug("Failed to signal process", "cmd", r, "signal", signalName(sig), "err", err)
+		}
 	}
+	if sig == syscall.SIGKILL {
+		r.cancel()
+	}
+}
+
+func cancelSignal(stage int) syscall.Signal {
+	switch stage {
+	case cancelInterrupt:
+		return syscall.SIGINT
+	case cancelTerminate:
+		return syscall.SIGTERM
+	}
+
+	return syscall.SIGKILL
+}
+
+func cancelStageString(stage int) string {
+	switch stage {
+	case cancelInterrupt:
+		return "Interrupting (SIGINT)"
+	case cancelTerminate:
+		return "Terminating (SIGTERM)"
+	case cancelKill:
+		return "Killing (SIGKILL)"
+	}
+
+	return ""
+}
+
+// classify recognizes the cause of failure.  Remote command output may describe connection failures
+// of other hosts, so these are only recognized when the transport failed to connect.
+func (r *Model) classify() Failure {
+	failure := Classify(r.output.String(), r.err)
+	if r.Remote() && failure.Connection() && !transport.ConnectionFailed(r.err) {
+		return FailureUnknown
+	}
+
+	return failure
+}
+
+// Remote is true if the command runs on the target via a transport.
+func (r *Model) Remote() bool {
+	return r.killer != nil
 }
 
 // Destination returns the SSH URL or `local`.
@@ -253,8 +641,18 @@ func (r *Model) Destination() string {
 	return r.dest
 }
 
-// String returns the requested command line.
+// SetLabel overrides the command line for display, ie to hide a wrapper.  Must be called before
+// Init.
+func (r *Model) SetLabel(label string) {
+	r.label = label
+}
+
+// String returns the label, or the requested command line.
 func (r *Model) String() string {
+	if r.label != "" {
+		return r.label
+	}
+
 	parts := append([]string{}, r.prog)
 	parts = append(parts, r.args...)
 	return strings.Join(parts, " ")
@@ -265,6 +663,10 @@ func (r *Model) StateString() string {
 	r.RLock()
 	defer r.RUnlock()
 
+	if r.state == stateRunning && r.cancelStage != cancelNone {
+		return cancelStageString(r.cancelStage)
+	}
+
 	return stateToString(r.state)
 }
 
@@ -278,6 +680,8 @@ func stateToString(state int) string {
 		return "Done"
 	case stateFailed:
 		return "Failed"
+	case stateTimedOut:
+		return "Timed Out"
 	}
 
 	return "Unknown"
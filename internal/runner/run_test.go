@@ -0,0 +1,505 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/labcoat/internal/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type updateMsg struct{}
+
+func onUpdate(*Model) tea.Msg { return updateMsg{} }
+
+// runToCompletion executes the runner's process synchronously.
+func runToCompletion(t *testing.T, r *Model) {
+	t.Helper()
+
+	batch, ok := r.Init()().(tea.BatchMsg)
+	require.True(t, ok, "Init should return a batch")
+	require.NotEmpty(t, batch)
+
+	// First command in batch runs the process.
+	batch[0]()
+	require.True(t, r.Complete())
+}
+
+func TestRemoteFakeTransport(t *testing.T) {
+	var got transport.FakeRequest
+	fake := &transport.Fake{
+		Dest: "fake://host",
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			got = req
+			fmt.Fprint(req.Stdout, "hello ")
+			fmt.Fprint(req.Stderr, "world")
+			return nil
+		},
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "echo", "hello", "world")
+	require.NoError(t, err)
+	runToCompletion(t, r)
+
+	assert.Equal(t, transport.FakeCommand, got.Kind)
+	assert.Contains(t, got.Cmd, "echo hello world")
+	assert.True(t, strings.HasPrefix(got.Cmd, "sh -c "), "Login shell may not be POSIX: %q", got.Cmd)
+	assert.True(t, r.Successful())
+	assert.Equal(t, "hello world", r.View())
+	assert.Equal(t, "fake://host", r.Destination())
+}
+
+func TestRemoteScriptFakeTransport(t *testing.T) {
+	var script []byte
+	var shell string
+	fake := &transport.Fake{
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			script, _ = io.ReadAll(req.Stdin)
+			shell = req.Shell
+			return errors.New("exit status 1")
+		},
+	}
+
+	r, err := NewRemoteScript(context.Background(), onUpdate, fake, "zsh", "test script", "",
+		"uptime\n")
+	require.NoError(t, err)
+	runToCompletion(t, r)
+
+	assert.True(t, strings.HasSuffix(string(script), "\nuptime\n"), "got script: %q", script)
+	assert.Equal(t, "zsh", shell)
+	assert.False(t, r.Successful())
+	assert.Equal(t, "Failed", r.StateString())
+	assert.Equal(t, "test script", r.String())
+}
+
+func TestCopyFrom(t *testing.T) {
+	fake := &transport.Fake{
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			fmt.Fprint(req.Stdout, "hello world")
+			return nil
+		},
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "true")
+	require.NoError(t, err)
+	runToCompletion(t, r)
+
+	var out strings.Builder
+	n, err := r.CopyFrom(&out, 6)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.Equal(t, "world", out.String())
+
+	n, err = r.CopyFrom(&out, 11)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestRemoteInput(t *testing.T) {
+	fake := &transport.Fake{
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			line, err := bufio.NewReader(req.Stdin).ReadString('\n')
+			fmt.Fprintf(req.Stdout, "got %q", line)
+			return err
+		},
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "read")
+	require.NoError(t, err)
+	require.NoError(t, r.EnableInput())
+	assert.True(t, r.InputEnabled())
+
+	require.NoError(t, r.WriteInput("secret\n"))
+	runToCompletion(t, r)
+
+	assert.True(t, r.Successful())
+	assert.Equal(t, `got "secret\n"`, r.View())
+	assert.False(t, r.InputEnabled(), "Input should be closed after exit")
+	assert.Error(t, r.WriteInput("late\n"))
+}
+
+func TestRemoteCloseInput(t *testing.T) {
+	fake := &transport.Fake{
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			b, err := io.ReadAll(req.Stdin)
+			fmt.Fprintf(req.Stdout, "read %d bytes", len(b))
+			return err
+		},
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "cat")
+	require.NoError(t, err)
+	require.NoError(t, r.EnableInput())
+	require.NoError(t, r.WriteInput("abc"))
+
+	// Close must be delivered as EOF so the process can exit.
+	go r.CloseInput()
+	runToCompletion(t, r)
+
+	assert.Equal(t, "read 3 bytes", r.View())
+}
+
+func TestRemoteSetInput(t *testing.T) {
+	fake := &transport.Fake{
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			b, err := io.ReadAll(req.Stdin)
+			fmt.Fprintf(req.Stdout, "read %q", b)
+			return err
+		},
+	}
+
+	// Commands reading beyond the provided input must see EOF, rather than wait.
+	r, err := NewRemote(context.Background(), onUpdate, fake, "cat")
+	require.NoError(t, err)
+	r.SetInput("password\n")
+	runToCompletion(t, r)
+
+	assert.Equal(t, `read "password\n"`, r.View())
+	assert.False(t, r.InputEnabled())
+}
+
+func TestWriteInputDoesNotBlock(t *testing.T) {
+	fake := &transport.Fake{
+		Handler: func(context.Context, transport.FakeRequest) error { return nil },
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "true")
+	require.NoError(t, err)
+	require.NoError(t, r.EnableInput())
+
+	// Nothing reads the pipe until started, so it fills; writes must fail rather than block.
+	line := strings.Repeat("x", 64*1024) + "\n"
+	for i := 0; i < 2*inputQueueLen && err == nil; i++ {
+		err = r.WriteInput(line)
+	}
+	assert.Error(t, err)
+
+	// Exiting releases the pipe writer.
+	runToCompletion(t, r)
+	assert.False(t, r.InputEnabled())
+}
+
+func TestCancelEscalates(t *testing.T) {
+	var mu sync.Mutex
+	var kills []string
+	fake := &transport.Fake{
+		Handler: func(ctx context.Context, req transport.FakeRequest) error {
+			if strings.Contains(req.Cmd, "kill -s") {
+				// Remote kill command; record the signal but let the process ignore it.
+				mu.Lock()
+				defer mu.Unlock()
+				_, sig, _ := strings.Cut(req.Cmd, "kill -s ")
+				kills = append(kills, strings.Fields(sig)[0])
+				return nil
+			}
+
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "sleep", "60")
+	require.NoError(t, err)
+	r.cancelGrace = 10 * time.Millisecond
+	go func() {
+		for r.StateString() != "Running" {
+			time.Sleep(time.Millisecond)
+		}
+		r.Cancel()
+	}()
+	runToCompletion(t, r)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"INT", "TERM", "KILL"}, kills)
+	assert.Equal(t, "Failed", r.StateString())
+	assert.Contains(t, r.View(), "[Interrupting (SIGINT)]")
+	assert.Contains(t, r.View(), "[Terminating (SIGTERM)]")
+	assert.Contains(t, r.View(), "[Killing (SIGKILL)]")
+}
+
+func TestCancelUnreachable(t *testing.T) {
+	var kills int32
+	fake := &transport.Fake{
+		Handler: func(ctx context.Context, req transport.FakeRequest) error {
+			if strings.Contains(req.Cmd, "kill -s") {
+				atomic.AddInt32(&kills, 1)
+				return &transport.ConnectError{Err: errors.New("connection refused")}
+			}
+
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "sleep", "60")
+	require.NoError(t, err)
+	r.cancelGrace = 10 * time.Millisecond
+	go func() {
+		for r.StateString() != "Running" {
+			time.Sleep(time.Millisecond)
+		}
+		r.Cancel()
+	}()
+	runToCompletion(t, r)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&kills), "Unreachable host should not be retried")
+	assert.Contains(t, r.View(), "[Killing (SIGKILL)]")
+}
+
+func TestCancelRepeated(t *testing.T) {
+	release := make(chan struct{})
+	fake := &transport.Fake{
+		Handler: func(ctx context.Context, req transport.FakeRequest) error {
+			if strings.Contains(req.Cmd, "kill -s") {
+				return nil
+			}
+
+			select {
+			case <-release:
+				return errors.New("exit status 130")
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "sleep", "60")
+	require.NoError(t, err)
+	r.cancelGrace = time.Hour
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runToCompletion(t, r)
+	}()
+	for r.StateString() != "Running" {
+		time.Sleep(time.Millisecond)
+	}
+
+	r.Cancel()
+	assert.Equal(t, "Interrupting (SIGINT)", r.StateString())
+	r.Cancel()
+	assert.Equal(t, "Terminating (SIGTERM)", r.StateString())
+
+	close(release)
+	<-done
+	assert.Equal(t, "Failed", r.StateString())
+
+	// Cancel after exit is a no-op.
+	r.Cancel()
+	assert.NotContains(t, r.View(), "SIGKILL")
+}
+
+func TestCancelStopsRemoteProcessGroup(t *testing.T) {
+	for _, prog := range []string{"setsid", "pgrep"} {
+		if _, err := exec.LookPath(prog); err != nil {
+			t.Skipf("%s not available: %v", prog, err)
+		}
+	}
+
+	// The local transport stands in for a remote host; the runner must stop the process group
+	// rather than relying on the death of the local client process.
+	r, err := NewRemote(context.Background(), onUpdate, &transport.Local{}, "sleep 30; echo done")
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		r.Cancel()
+	}()
+
+	start := time.Now()
+	runToCompletion(t, r)
+
+	assert.Less(t, time.Since(start), 10*time.Second)
+	assert.False(t, r.Successful())
+	assert.NotContains(t, r.View(), "done")
+}
+
+func TestCancelWithoutSetsidWait(t *testing.T) {
+	if _, err := exec.LookPath("pgrep"); err != nil {
+		t.Skipf("pgrep not available: %v", err)
+	}
+
+	// Emulates busybox setsid, which does not support -w.
+	dir := t.TempDir()
+	setsid := "#!/bin/sh\necho \"setsid: unrecognized option: w\" >&2\nexit 1\n"
+	require.NoError(t, os.WriteFile(dir+"/setsid", []byte(setsid), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r, err := NewRemote(context.Background(), onUpdate, &transport.Local{}, "echo", "hello")
+	require.NoError(t, err)
+	runToCompletion(t, r)
+	require.True(t, r.Successful(), r.View())
+	assert.Equal(t, "hello\n", r.View())
+
+	// Without a new session, the wrapper's children must still be stopped.
+	r, err = NewRemote(context.Background(), onUpdate, &transport.Local{}, "sleep 30; echo done")
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		r.Cancel()
+	}()
+
+	start := time.Now()
+	runToCompletion(t, r)
+
+	assert.Less(t, time.Since(start), 10*time.Second)
+	assert.False(t, r.Successful())
+	assert.NotContains(t, r.View(), "done")
+}
+
+func TestTimeout(t *testing.T) {
+	fake := &transport.Fake{
+		Handler: func(ctx context.Context, req transport.FakeRequest) error {
+			if strings.Contains(req.Cmd, "kill -s") {
+				return nil
+			}
+
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "sleep", "60")
+	require.NoError(t, err)
+	r.SetTimeout(20 * time.Millisecond)
+	r.cancelGrace = 10 * time.Millisecond
+	runToCompletion(t, r)
+
+	assert.True(t, r.TimedOut())
+	assert.False(t, r.Successful())
+	assert.Equal(t, "Timed Out", r.StateString())
+	assert.Contains(t, r.View(), "[Timed out after 20ms]")
+}
+
+func TestTimeoutNotReached(t *testing.T) {
+	fake := &transport.Fake{
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			fmt.Fprint(req.Stdout, "quick")
+			return nil
+		},
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "true")
+	require.NoError(t, err)
+	r.SetTimeout(time.Hour)
+	runToCompletion(t, r)
+
+	assert.False(t, r.TimedOut())
+	assert.True(t, r.Successful())
+	assert.Equal(t, "quick", r.View())
+}
+
+func TestFailureHint(t *testing.T) {
+	fake := &transport.Fake{
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			fmt.Fprintln(req.Stderr, "ssh: Could not resolve hostname nope: Name or service not known")
+			return &transport.ConnectError{Err: transport.FakeExitError(255)}
+		},
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "uptime")
+	require.NoError(t, err)
+	runToCompletion(t, r)
+	r.waitForOutput()()
+
+	assert.Equal(t, FailureDNS, r.Failure())
+	assert.Contains(t, r.View(), "[Failed: Host name lookup failed]\n["+FailureDNS.Hint()+"]")
+}
+
+func TestFailureFromCommandOutput(t *testing.T) {
+	// The remote command itself failed to reach another host.
+	fake := &transport.Fake{
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			fmt.Fprintln(req.Stderr, "Host key verification failed.")
+			return transport.FakeExitError(1)
+		},
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "ssh", "builder", "true")
+	require.NoError(t, err)
+	runToCompletion(t, r)
+	assert.Equal(t, FailureUnknown, r.Failure())
+
+	// Command failures are recognized regardless of the transport.
+	fake.Handler = func(_ context.Context, req transport.FakeRequest) error {
+		fmt.Fprintln(req.Stderr, "sh: nixos-version: not found")
+		return transport.FakeExitError(127)
+	}
+	r, err = NewRemote(context.Background(), onUpdate, fake, "nixos-version")
+	require.NoError(t, err)
+	runToCompletion(t, r)
+	assert.Equal(t, FailureMissingBinary, r.Failure())
+}
+
+func TestDisconnectOK(t *testing.T) {
+	fake := &transport.Fake{
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			fmt.Fprintln(req.Stderr, "Connection to web closed by remote host.")
+			return errors.New("exit status 255")
+		},
+	}
+
+	r, err := NewRemote(context.Background(), onUpdate, fake, "reboot")
+	require.NoError(t, err)
+	r.SetDisconnectOK()
+	runToCompletion(t, r)
+	r.waitForOutput()()
+
+	assert.True(t, r.Successful())
+	assert.Contains(t, r.View(), "[Done: Connection closed by host]")
+}
+
+func TestLocalEnv(t *testing.T) {
+	t.Setenv("LABCOAT_TEST_SET", "value")
+	require.NoError(t, os.Unsetenv("LABCOAT_TEST_UNSET"))
+
+	r := NewLocal(context.Background(), onUpdate, "", "sh", "-c",
+		`echo "$LABCOAT_TEST_SET,${LABCOAT_TEST_UNSET-unset},$EXTRA"`)
+	assert.Nil(t, r.Env(), "Parent environment should be inherited by default")
+
+	r.PassEnv("LABCOAT_TEST_SET")
+	r.PassEnv("LABCOAT_TEST_UNSET")
+	r.SetEnv("EXTRA", "x")
+	assert.Equal(t, []string{"LABCOAT_TEST_SET=value", "EXTRA=x"}, r.Env())
+
+	runToCompletion(t, r)
+	assert.Equal(t, "value,unset,x\n", r.View())
+}
+
+func TestExitCode(t *testing.T) {
+	r := NewLocal(context.Background(), onUpdate, "", "sh", "-c", "exit 3")
+	assert.Equal(t, -1, r.ExitCode(), "Exit code should be unknown before running")
+	assert.True(t, r.Started().IsZero())
+
+	runToCompletion(t, r)
+	assert.Equal(t, 3, r.ExitCode())
+	assert.False(t, r.Started().IsZero())
+	assert.False(t, r.Finished().Before(r.Started()))
+
+	r = NewLocal(context.Background(), onUpdate, "", "true")
+	runToCompletion(t, r)
+	assert.Equal(t, 0, r.ExitCode())
+
+	// Fake transport errors carry no exit code.
+	fake := &transport.Fake{
+		Handler: func(_ context.Context, _ transport.FakeRequest) error {
+			return errors.New("exit status 255")
+		},
+	}
+	r, err := NewRemote(context.Background(), onUpdate, fake, "false")
+	require.NoError(t, err)
+	runToCompletion(t, r)
+	assert.Equal(t, -1, r.ExitCode())
+}
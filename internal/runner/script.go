@@ -1,57 +1,385 @@
 package runner
 
-import "strings"
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 const (
-	labelStart = "[label{{{"
-	labelEnd   = "}}}label]"
+	markerPrefix = "@@labcoat-" // Followed by nonce, ":", kind, ":", payload, markerEnd.
+	markerEnd    = "@@"
+
+	markerKindLabel  = "L" // Payload is the base64 encoded section label.
+	markerKindResult = "R" // Payload is "<exit code> <start time> <end time>".
+
+	// Exit code reported by coreutils `timeout` when the time limit is reached.
+	timeoutExitCode = 124
 )
 
-func NewScript(cmds []string) string {
-	result := ""
+// Defines the script helpers, which must work in any POSIX sh including busybox & dash.  _lc_now
+// sets $_lc_t to the current epoch time, using $EPOCHREALTIME when available to avoid forking;
+// fractional seconds are dropped if date does not support %N, and it is 0 without date.  Commands
+// run without a time limit when timeout is not installed.
+const scriptPrologue = `_lc_now() {
+	_lc_t=${EPOCHREALTIME:-}
+	[ -n "$_lc_t" ] && return
+	_lc_t=$(date +%s.%N 2>/dev/null) || _lc_t=$(date +%s 2>/dev/null) || _lc_t=0
+	case $_lc_t in *.*[!0-9]*) _lc_t=${_lc_t%%.*} ;; esac
+}
+_lc_timeout=
+command -v timeout >/dev/null 2>&1 && _lc_timeout=timeout
+
+`
+
+// ScriptCmd is a command to be run as a labeled section of a script.
+type ScriptCmd struct {
+	Cmd     string
+	Label   string        // Section label, defaults to Cmd.
+	Timeout time.Duration // Zero disables the timeout.
+}
+
+// Section describes a labeled section of script output.
+type Section struct {
+	Label    string
+	Complete bool          // True once the section's command has exited.
+	ExitCode int           // Exit code of the command, valid when Complete.
+	TimedOut bool          // True if the command was killed by its timeout.
+	Elapsed  time.Duration // Run time of the command, valid when Complete.
+}
+
+// Successful is true if the section completed with a zero exit code.
+func (s Section) Successful() bool {
+	return s.Complete && !s.TimedOut && s.ExitCode == 0
+}
+
+// Script is a POSIX sh script made up of labeled command sections.  Section boundaries are written
+// to the script output as markers containing a random per-script nonce, so that command output
+// cannot be mistaken for a marker.
+type Script struct {
+	nonce string
+	cmds  []ScriptCmd
+}
+
+// NewScript constructs a script that runs each of the commands in turn.
+func NewScript(cmds []ScriptCmd) (*Script, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Script{nonce: nonce, cmds: cmds}, nil
+}
+
+// newNonce returns a random hex string, which remote output and paths cannot collide with.
+func newNonce() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// String renders the script as sh source.  Each command is preceded by a label marker, and
+// followed by a result marker containing its exit code and timestamps.  Commands with a timeout
+// are run by $_lc_sh, as exported by the transport, defaulting to sh.
+func (s *Script) String() string {
+	result := scriptPrologue
+
+	for _, cmd := range s.cmds {
+		text := cmd.Label
+		if text == "" {
+			text = cmd.Cmd
+		}
+		label := s.marker(markerKindLabel, encodeLabel(text))
+		result += "printf '%s\\n' '" + label + "'\n"
+		result += "_lc_now; _lc_start=$_lc_t\n"
+
+		// Redirect stdin, otherwise the command may consume the remainder of this script.
+		if cmd.Timeout > 0 {
+			secs := strconv.FormatFloat(cmd.Timeout.Seconds(), 'f', -1, 64)
+			result += "$_lc_timeout ${_lc_timeout:+" + secs + "s} \"${_lc_sh:-sh}\" -c " +
+				singleQuote(cmd.Cmd) + " </dev/null\n"
+			result += "_lc_rc=$?\n"
+			result += "[ -n \"$_lc_timeout\" ] && [ $_lc_rc -eq " + strconv.Itoa(timeoutExitCode) +
+				" ] && _lc_rc=timeout\n"
+		} else {
+			result += "{\n" + cmd.Cmd + "\n} </dev/null\n"
+			result += "_lc_rc=$?\n"
+		}
 
-	for _, cmd := range cmds {
-		result += "echo \"" + labelStart + escape(cmd) + labelEnd + "\"\n"
-		result += cmd + "\n\n"
+		resultFmt := s.marker(markerKindResult, "%s %s %s")
+		result += "_lc_now\n"
+		result += "printf '" + resultFmt + "\\n' \"$_lc_rc\" \"$_lc_start\" \"$_lc_t\"\n\n"
 	}
 
 	return result
 }
 
-func escape(cmd string) string {
-	return strings.ReplaceAll(cmd, "\"", "\\\"")
+// FormatOutput replaces label markers in script output with the result of `labelFn`, and removes
+// result markers.  Sections are passed to `labelFn` with their results if they have completed.
+// When `partial` is true, the output is still being written, and a trailing incomplete marker is
+// withheld.
+func (s *Script) FormatOutput(output string, partial bool, labelFn func(Section) string) string {
+	p := s.NewParser()
+	_, _ = p.Write([]byte(output))
+	if !partial {
+		p.Flush()
+	}
+
+	return p.Format(labelFn)
 }
 
-func FormatOutput(s string, labelFn func(string) string) string {
-	var out string
+// NewParser returns a parser for the output of this script.
+func (s *Script) NewParser() *Parser {
+	return &Parser{prefix: markerPrefix + s.nonce + ":"}
+}
 
-	startLen := len(labelStart)
-	endLen := len(labelEnd)
+func (s *Script) marker(kind string, payload string) string {
+	return markerPrefix + s.nonce + ":" + kind + ":" + payload + markerEnd
+}
 
-	for {
-		start := strings.Index(s, labelStart)
-		if start == -1 {
+// encodeLabel base64 encodes a label, so it is never interpreted by the shell.
+func encodeLabel(label string) string {
+	return base64.StdEncoding.EncodeToString([]byte(label))
+}
+
+// singleQuote quotes s for use as a single sh word.
+func singleQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Parser incrementally splits script output into text and markers.  Output may be written in
+// arbitrary chunks; markers split across writes are reassembled.
+type Parser struct {
+	prefix  string // Marker prefix including nonce.
+	pending string // Unparsed input, which may contain the start of a marker.
+	tokens  []token
+	text    strings.Builder // Text following the last marker token.
+	trimNL  bool            // Discard a leading newline from the next write, it follows a marker.
+}
+
+// Write implements io.Writer.
+func (p *Parser) Write(b []byte) (int, error) {
+	p.pending += string(b)
+	if p.trimNL && p.pending != "" {
+		p.pending = strings.TrimPrefix(p.pending, "\n")
+		p.trimNL = false
+	}
+	p.parse()
+
+	return len(b), nil
+}
+
+// Flush treats any withheld input as text; call once all output has been written.
+func (p *Parser) Flush() {
+	p.appendText(p.pending)
+	p.pending = ""
+	p.trimNL = false
+}
+
+// Sections returns the sections parsed so far.
+func (p *Parser) Sections() []Section {
+	var sections []Section
+	for i, tok := range p.tokens {
+		if tok.kind == tokenLabel {
+			sections = append(sections, p.section(i))
+		}
+	}
+
+	return sections
+}
+
+// Format renders the output parsed so far, replacing label markers with the result of `labelFn`
+// and removing result markers.
+func (p *Parser) Format(labelFn func(Section) string) string {
+	var out strings.Builder
+	for i, tok := range p.tokens {
+		switch tok.kind {
+		case tokenText:
+			out.WriteString(tok.text)
+		case tokenLabel:
+			out.WriteString(labelFn(p.section(i)))
+		}
+	}
+	out.WriteString(p.text.String())
+
+	return out.String()
+}
+
+// section builds the Section for the label token at index i, including its result if present.
+func (p *Parser) section(i int) Section {
+	section := Section{Label: p.tokens[i].text}
+
+	// Locate the result for this section, before the next label.
+	for _, next := range p.tokens[i+1:] {
+		if next.kind == tokenLabel {
 			break
 		}
+		if next.kind == tokenResult {
+			parseResult(&section, next.text)
+			break
+		}
+	}
+
+	return section
+}
 
-		if start > 0 {
-			out += s[:start]
+func (p *Parser) parse() {
+	for {
+		start := strings.Index(p.pending, p.prefix)
+		if start == -1 {
+			// Withhold any suffix that could be the beginning of a marker.
+			keep := partialPrefixLen(p.pending, p.prefix)
+			p.appendText(p.pending[:len(p.pending)-keep])
+			p.pending = p.pending[len(p.pending)-keep:]
+			return
 		}
-		s = s[start+startLen:]
 
-		label := s
-		end := strings.Index(s, labelEnd)
+		body := p.pending[start+len(p.prefix):]
+		end := strings.Index(body, markerEnd)
+		nl := strings.IndexByte(body, '\n')
+		if nl != -1 && (end == -1 || nl < end) {
+			// Markers never span lines; treat the prefix as text.
+			p.appendText(p.pending[:start+len(p.prefix)])
+			p.pending = body
+			continue
+		}
 		if end == -1 {
-			// No end marker; render the rest of `s` as label.
-			s = ""
-		} else {
-			label = s[:end]
-			s = s[end+endLen:]
+			// Incomplete marker, wait for more input.
+			p.appendText(p.pending[:start])
+			p.pending = p.pending[start:]
+			return
+		}
+
+		markerLen := len(p.prefix) + end + len(markerEnd)
+		tok, ok := parseMarker(body[:end])
+		if !ok {
+			// Malformed marker; treat it as text.
+			p.appendText(p.pending[:start+markerLen])
+			p.pending = p.pending[start+markerLen:]
+			continue
 		}
-		out += labelFn(label)
+
+		p.appendText(p.pending[:start])
+		p.appendToken(tok)
+		p.pending = p.pending[start+markerLen:]
+
+		// Discard the newline written after each marker, which may arrive in a later write.
+		if p.pending == "" {
+			p.trimNL = true
+			return
+		}
+		p.pending = strings.TrimPrefix(p.pending, "\n")
 	}
+}
 
-	out += s
+// appendText adds s to the text following the last marker, which may grow large.
+func (p *Parser) appendText(s string) {
+	p.text.WriteString(s)
+}
+
+// appendToken adds a marker token, preceded by any text written since the last marker.
+func (p *Parser) appendToken(tok token) {
+	if p.text.Len() > 0 {
+		p.tokens = append(p.tokens, token{kind: tokenText, text: p.text.String()})
+		p.text.Reset()
+	}
+	p.tokens = append(p.tokens, tok)
+}
+
+// partialPrefixLen returns the length of the longest suffix of s that is a prefix of prefix.
+func partialPrefixLen(s, prefix string) int {
+	n := min(len(s), len(prefix)-1)
+	for ; n > 0; n-- {
+		if strings.HasSuffix(s, prefix[:n]) {
+			return n
+		}
+	}
+
+	return 0
+}
+
+const (
+	tokenText = iota
+	tokenLabel
+	tokenResult
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+// parseMarker decodes the marker body following the nonce: "<kind>:<payload>".
+func parseMarker(body string) (token, bool) {
+	kind, payload, ok := strings.Cut(body, ":")
+	if !ok {
+		return token{}, false
+	}
+
+	switch kind {
+	case markerKindLabel:
+		label, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return token{}, false
+		}
+		return token{kind: tokenLabel, text: string(label)}, true
+
+	case markerKindResult:
+		return token{kind: tokenResult, text: payload}, true
+	}
+
+	return token{}, false
+}
+
+// parseResult populates section from the content of a result marker: "<rc> <start> <end>".
+func parseResult(section *Section, result string) {
+	fields := strings.Fields(result)
+	if len(fields) != 3 {
+		return
+	}
+
+	section.Complete = true
+	if fields[0] == "timeout" {
+		section.TimedOut = true
+		section.ExitCode = timeoutExitCode
+	} else {
+		section.ExitCode, _ = strconv.Atoi(fields[0])
+	}
+
+	start, serr := parseEpoch(fields[1])
+	end, eerr := parseEpoch(fields[2])
+	if serr == nil && eerr == nil && end >= start {
+		section.Elapsed = end - start
+	}
+}
+
+// parseEpoch parses an epoch time in seconds with optional fractional digits, from date or bash's
+// $EPOCHREALTIME, which uses a locale specific decimal separator.
+func parseEpoch(s string) (time.Duration, error) {
+	secs, frac, _ := strings.Cut(strings.Replace(s, ",", ".", 1), ".")
+
+	n, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	d := time.Duration(n) * time.Second
+
+	if frac != "" {
+		// Pad or truncate fractional digits to nanoseconds.
+		frac = (frac + "000000000")[:9]
+		n, err = strconv.ParseInt(frac, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(n)
+	}
 
-	return out
+	return d, nil
 }
@@ -1,35 +1,284 @@
 package runner
 
 import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestFormatOutput(t *testing.T) {
-	renderFn := func(s string) string {
-		return "|:" + s + ":|"
+// testScript returns a script with a fixed nonce, along with the label & result marker functions.
+func testScript() (*Script, func(string) string, func(string) string) {
+	s := &Script{nonce: "abc123"}
+	label := func(l string) string {
+		return s.marker(markerKindLabel, encodeLabel(l)) + "\n"
+	}
+	result := func(r string) string {
+		return s.marker(markerKindResult, r) + "\n"
+	}
+
+	return s, label, result
+}
+
+func renderSection(s Section) string {
+	if s.Complete {
+		return fmt.Sprintf("|:%s:%d:%v:|", s.Label, s.ExitCode, s.Elapsed)
 	}
+	return "|:" + s.Label + ":|"
+}
+
+func TestFormatOutput(t *testing.T) {
+	script, label, result := testScript()
 
 	tcs := map[string]struct {
 		in, want string
 	}{
-		"empty str":    {in: "", want: ""},
-		"plain str":    {in: "hello world", want: "hello world"},
-		"naked":        {in: "[label{{{naked}}}label]", want: "|:naked:|"},
-		"empty":        {in: "[label{{{}}}label]", want: "|::|"},
-		"simple":       {in: "abc[label{{{naked}}}label]def", want: "abc|:naked:|def"},
-		"spaces":       {in: "abc [label{{{two words}}}label] def", want: "abc |:two words:| def"},
-		"nlsuffix":     {in: "abc[label{{{naked}}}label]\ndef", want: "abc|:naked:|\ndef"},
-		"nllabel":      {in: "abc[label{{{new\nline}}}label]def", want: "abc|:new\nline:|def"},
-		"unterminated": {in: "abc [label{{{no term!", want: "abc |:no term!:|"},
+		"empty str":      {in: "", want: ""},
+		"plain str":      {in: "hello world", want: "hello world"},
+		"naked":          {in: label("naked"), want: "|:naked:|"},
+		"empty":          {in: label(""), want: "|::|"},
+		"simple":         {in: "abc" + label("naked") + "def", want: "abc|:naked:|def"},
+		"spaces":         {in: "abc " + label("two words") + " def", want: "abc |:two words:| def"},
+		"nllabel":        {in: "abc" + label("new\nline") + "def", want: "abc|:new\nline:|def"},
+		"shell chars":    {in: label("echo \"$HOME\" `id` \\"), want: "|:echo \"$HOME\" `id` \\:|"},
+		"unterminated":   {in: "abc @@labcoat-abc123:L:bm8gdGVybSE=", want: "abc @@labcoat-abc123:L:bm8gdGVybSE="},
+		"wrong nonce":    {in: "@@labcoat-ffff:L:YQ==@@\n", want: "@@labcoat-ffff:L:YQ==@@\n"},
+		"malformed kind": {in: "@@labcoat-abc123:Z:YQ==@@\nx", want: "@@labcoat-abc123:Z:YQ==@@\nx"},
+		"bad base64":     {in: "@@labcoat-abc123:L:!!@@\n", want: "@@labcoat-abc123:L:!!@@\n"},
+		"old protocol":   {in: "[label{{{a}}}label]", want: "[label{{{a}}}label]"},
+		"result": {
+			in:   label("a") + "out\n" + result("0 10.5 12.75"),
+			want: "|:a:0:2.25s:|out\n",
+		},
+		"result comma": {
+			in:   label("a") + result("3 10,000001 10,000002"),
+			want: "|:a:3:1µs:|",
+		},
+		"second pending": {
+			in:   label("a") + "x\n" + result("1 1.0 2.0") + label("b") + "y",
+			want: "|:a:1:1s:|x\n|:b:|y",
+		},
 	}
 	for name, tc := range tcs {
 		tc := tc
 		t.Run(name, func(t *testing.T) {
-			got := FormatOutput(tc.in, renderFn)
+			got := script.FormatOutput(tc.in, false, renderSection)
 
 			assert.Equal(t, tc.want, got)
 		})
 	}
 }
+
+func TestFormatOutputPartial(t *testing.T) {
+	script, label, _ := testScript()
+
+	in := "abc" + label("a") + "def@@labc"
+	got := script.FormatOutput(in, true, renderSection)
+	assert.Equal(t, "abc|:a:|def", got, "Partial marker should be withheld")
+
+	got = script.FormatOutput(in, false, renderSection)
+	assert.Equal(t, "abc|:a:|def@@labc", got, "Partial marker should be flushed as text")
+}
+
+func TestFormatOutputTimeout(t *testing.T) {
+	script, label, result := testScript()
+
+	var got Section
+	script.FormatOutput(label("sleep 5")+result("timeout 1.0 2.0"), false,
+		func(s Section) string {
+			got = s
+			return ""
+		})
+
+	assert.True(t, got.Complete)
+	assert.True(t, got.TimedOut)
+	assert.False(t, got.Successful())
+	assert.Equal(t, time.Second, got.Elapsed)
+}
+
+func TestParserSplitWrites(t *testing.T) {
+	script, label, result := testScript()
+	in := "before\n" + label("a") + "out\n" + result("0 1.0 2.0") + "after"
+
+	// Feed input one byte at a time.
+	p := script.NewParser()
+	for i := range in {
+		_, err := p.Write([]byte{in[i]})
+		require.NoError(t, err)
+	}
+	p.Flush()
+
+	assert.Equal(t, "before\n|:a:0:1s:|out\nafter", p.Format(renderSection))
+	assert.Equal(t, []Section{
+		{Label: "a", Complete: true, Elapsed: time.Second},
+	}, p.Sections())
+}
+
+func TestNewScriptNonce(t *testing.T) {
+	a, err := NewScript(nil)
+	require.NoError(t, err)
+	b, err := NewScript(nil)
+	require.NoError(t, err)
+
+	assert.Len(t, a.nonce, 16)
+	assert.NotEqual(t, a.nonce, b.nonce)
+}
+
+func TestNewScriptTimeout(t *testing.T) {
+	s, err := NewScript([]ScriptCmd{
+		{Cmd: "echo 'hi'", Timeout: 1500 * time.Millisecond},
+		{Cmd: "uptime"},
+	})
+	require.NoError(t, err)
+	script := s.String()
+
+	assert.Contains(t, script,
+		`$_lc_timeout ${_lc_timeout:+1.5s} "${_lc_sh:-sh}" -c 'echo '\''hi'\''' </dev/null`)
+	assert.Contains(t, script, "{\nuptime\n} </dev/null")
+}
+
+func TestScriptShells(t *testing.T) {
+	for _, shell := range []string{"bash", "sh", "dash", "busybox"} {
+		t.Run(shell, func(t *testing.T) {
+			path, err := exec.LookPath(shell)
+			if err != nil {
+				t.Skip(shell + " not available")
+			}
+
+			script, err := NewScript([]ScriptCmd{
+				{Cmd: "echo \"$HOME\" `true` \\ ok"},
+				{Cmd: "false"},
+				{Cmd: "sleep 5", Timeout: 100 * time.Millisecond},
+			})
+			require.NoError(t, err)
+
+			args := []string{"-s"}
+			if shell == "busybox" {
+				args = []string{"sh", "-s"}
+			}
+			cmd := exec.Command(path, args...)
+			cmd.Stdin = strings.NewReader(script.String())
+			cmd.Env = []string{"HOME=/home", "PATH=" + os.Getenv("PATH")}
+			output, err := cmd.CombinedOutput()
+			require.NoError(t, err, string(output))
+
+			p := script.NewParser()
+			_, _ = p.Write(output)
+			p.Flush()
+
+			sections := p.Sections()
+			require.Len(t, sections, 3)
+			assert.Equal(t, "echo \"$HOME\" `true` \\ ok", sections[0].Label)
+			assert.True(t, sections[0].Successful())
+			assert.Equal(t, 1, sections[1].ExitCode)
+			assert.True(t, sections[2].TimedOut)
+			assert.Greater(t, sections[2].Elapsed, 50*time.Millisecond)
+
+			got := p.Format(func(s Section) string { return "[" + s.Label + "]\n" })
+			assert.Contains(t, got, "/home  ok\n")
+			assert.NotContains(t, got, script.nonce)
+		})
+	}
+}
+
+func TestScriptWithoutTimeout(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+
+	// PATH contains only sh, so neither timeout nor date are available.
+	dir := t.TempDir()
+	require.NoError(t, os.Symlink(sh, filepath.Join(dir, "sh")))
+
+	script, err := NewScript([]ScriptCmd{{Cmd: "exit 3", Timeout: time.Minute}})
+	require.NoError(t, err)
+	cmd := exec.Command(sh, "-s")
+	cmd.Stdin = strings.NewReader(script.String())
+	cmd.Env = []string{"PATH=" + dir}
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	p := script.NewParser()
+	_, _ = p.Write(output)
+	p.Flush()
+
+	sections := p.Sections()
+	require.Len(t, sections, 1)
+	assert.True(t, sections[0].Complete)
+	assert.False(t, sections[0].TimedOut)
+	assert.Equal(t, 3, sections[0].ExitCode)
+}
+
+func TestParseEpoch(t *testing.T) {
+	tcs := []struct {
+		input string
+		want  time.Duration
+	}{
+		{"1700000000", 1700000000 * time.Second},
+		{"1700000000.5", 1700000000*time.Second + 500*time.Millisecond},
+		{"1700000000,000001", 1700000000*time.Second + time.Microsecond},
+		{"1700000000.123456789", 1700000000*time.Second + 123456789},
+	}
+
+	for _, tc := range tcs {
+		got, err := parseEpoch(tc.input)
+		require.NoError(t, err, tc.input)
+		assert.Equal(t, tc.want, got, tc.input)
+	}
+}
+
+func FuzzParser(f *testing.F) {
+	script, label, result := testScript()
+	f.Add(label("a")+"out\n"+result("0 1.0 2.0"), 3)
+	f.Add("@@labcoat-abc123:L:YQ==@@", 12)
+	f.Add("x@@labcoat-abc123:R:0 1 2@@\n@@", 1)
+	f.Add("@@labcoat-abc123:L:\n@@labcoat-abc123:L:Yg==@@\n", 20)
+
+	f.Fuzz(func(t *testing.T, in string, split int) {
+		if split < 0 || split > len(in) {
+			split = len(in) / 2
+		}
+
+		whole := script.NewParser()
+		_, _ = whole.Write([]byte(in))
+		whole.Flush()
+
+		parts := script.NewParser()
+		_, _ = parts.Write([]byte(in[:split]))
+		_, _ = parts.Write([]byte(in[split:]))
+		parts.Flush()
+
+		// Output must not depend on how it was split across writes.
+		assert.Equal(t, whole.Format(renderSection), parts.Format(renderSection))
+		assert.Equal(t, whole.Sections(), parts.Sections())
+
+		// Input without markers must pass through unchanged.
+		if !strings.Contains(in, script.NewParser().prefix) {
+			assert.Equal(t, in, whole.Format(renderSection))
+		}
+	})
+}
+
+func FuzzLabelRoundTrip(f *testing.F) {
+	f.Add("uptime")
+	f.Add("echo \"$(id)\" `x` \\ '@@'")
+
+	f.Fuzz(func(t *testing.T, label string) {
+		script, mark, _ := testScript()
+
+		p := script.NewParser()
+		_, _ = p.Write([]byte(mark(label)))
+		p.Flush()
+
+		sections := p.Sections()
+		require.Len(t, sections, 1)
+		assert.Equal(t, label, sections[0].Label)
+	})
+}
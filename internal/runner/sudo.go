@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jhillyerd/labcoat/internal/transport"
+)
+
+// Files listed in $_lc_tmp are removed when the remote shell exits.
+const cleanupTrap = "trap 'rm -f $_lc_tmp' EXIT"
+
+// Writes an askpass helper that echos the password in $_lc_secret back to sudo.  The password is
+// never placed on a command line or in a file.  It is exported for commands run under timeout;
+// sudo resets the environment of the elevated command.
+const sudoAskpass = `SUDO_ASKPASS=$(mktemp) || exit 1; export SUDO_ASKPASS; ` +
+	`_lc_tmp="$_lc_tmp $SUDO_ASKPASS"; ` + cleanupTrap + `; ` +
+	`printf '#!/bin/sh\nprintf "%%s\\n" "$_lc_secret"\n' >"$SUDO_ASKPASS"; ` +
+	`chmod 700 "$SUDO_ASKPASS"`
+
+// Reads the password from the first line of stdin, which sh -c leaves to the read builtin.
+const sudoPrologue = `IFS= read -r _lc_secret; export _lc_secret; ` + sudoAskpass
+
+// Sudo elevates remote commands with sudo, authenticating with Password via an askpass helper when
+// set.
+type Sudo struct {
+	Password string // Empty if sudo does not require a password.
+}
+
+// Wrap returns cmdline elevated by sudo, as a command line for the target's login shell.  When a
+// password is required, Input must be written to the command stdin before any other input.
+func (s *Sudo) Wrap(cmdline string) string {
+	if s.Password == "" {
+		return transport.ShCmdline(s.command(cmdline))
+	}
+
+	return transport.ShCmdline(sudoPrologue + "; " + s.command(cmdline))
+}
+
+// WrapScript returns the script commands elevated by sudo, and the complete script prologue.
+// When a password is required, the script must be run with Secret, see NewRemoteScript.  It
+// cannot be read from the script stdin, as the interpreter may have read ahead of the prologue.
+func (s *Sudo) WrapScript(cmds []ScriptCmd) (prologue string, wrapped []ScriptCmd) {
+	wrapped = make([]ScriptCmd, len(cmds))
+	for i, c := range cmds {
+		if c.Label == "" {
+			c.Label = c.Cmd
+		}
+		c.Cmd = s.command(c.Cmd)
+		wrapped[i] = c
+	}
+	if s.Password != "" {
+		prologue = sudoAskpass + "\n"
+	}
+
+	return prologue, wrapped
+}
+
+// Secret returns the password for a wrapped script, empty if no password is required.
+func (s *Sudo) Secret() string {
+	return s.Password
+}
+
+// Input returns the stdin preamble carrying the password, empty if no password is required.
+func (s *Sudo) Input() string {
+	if s.Password == "" {
+		return ""
+	}
+
+	return s.Password + "\n"
+}
+
+func (s *Sudo) command(cmdline string) string {
+	return s.prefix() + " sh -c " + singleQuote(cmdline)
+}
+
+// prefix returns the sudo invocation that a command and its arguments follow.
+func (s *Sudo) prefix() string {
+	if s.Password == "" {
+		return "sudo -n --"
+	}
+
+	return "sudo -A --"
+}
+
+// SudoRequiresPassword checks whether sudo on the target requires a password.
+func SudoRequiresPassword(ctx context.Context, t transport.Transport) (bool, error) {
+	output, err := runCheck(ctx, t, "", "sudo", "-n", "true")
+	if err == nil {
+		return false, nil
+	}
+	if strings.Contains(output, "password is required") {
+		return true, nil
+	}
+
+	return false, &CheckError{Output: output, Err: err, Failure: Classify(output, err)}
+}
+
+// CheckError describes a failed check command.
+type CheckError struct {
+	Output  string
+	Err     error
+	Failure Failure
+}
+
+func (e *CheckError) Error() string {
+	if e.Failure != FailureUnknown {
+		return e.Failure.String() + ": " + e.Output
+	}
+	if e.Output != "" {
+		return e.Output
+	}
+
+	return e.Err.Error()
+}
+
+func (e *CheckError) Unwrap() error { return e.Err }
@@ -0,0 +1,155 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/labcoat/internal/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Emulates sudo: -A checks the askpass helper output, -n requires $NOPASSWD.  The elevated
+// command runs without the password variable, as sudo resets its environment.  Arguments are
+// appended to $SUDO_LOG when set.
+const fakeSudo = `#!/bin/sh
+[ -n "$SUDO_LOG" ] && echo "$*" >>"$SUDO_LOG"
+mode=$1; shift; [ "$1" = -- ] && shift
+case $mode in
+-A) [ "$("$SUDO_ASKPASS")" = secret ] || { echo "sudo: 1 incorrect password attempt" >&2; exit 1; } ;;
+-n) [ -n "$NOPASSWD" ] || { echo "sudo: a password is required" >&2; exit 1; } ;;
+esac
+exec env -u _lc_secret "$@"
+`
+
+func installFakeSudo(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sudo"), []byte(fakeSudo), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("TMPDIR", t.TempDir())
+}
+
+func TestSudoWrapPassword(t *testing.T) {
+	installFakeSudo(t)
+
+	sudo := &Sudo{Password: "secret"}
+	cmd := sudo.Wrap(`echo "pw=$_lc_secret"; read line; echo "got $line"; echo "$SUDO_ASKPASS"`)
+	assert.True(t, strings.HasPrefix(cmd, "sh -c '"), "Login shell may not be POSIX: %q", cmd)
+	r, err := NewRemote(context.Background(), onUpdate, &transport.Local{}, cmd)
+	require.NoError(t, err)
+	require.NoError(t, r.EnableInput())
+	require.NoError(t, r.WriteInput(sudo.Input()+"hello\n"))
+	runToCompletion(t, r)
+
+	require.True(t, r.Successful(), r.View())
+	lines := strings.Split(strings.TrimSpace(r.View()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "pw=", lines[0], "Password must not reach the elevated command")
+	assert.Equal(t, "got hello", lines[1])
+
+	_, err = os.Stat(lines[2])
+	assert.ErrorIs(t, err, os.ErrNotExist, "Askpass helper should be removed")
+}
+
+func TestSudoWrapWrongPassword(t *testing.T) {
+	installFakeSudo(t)
+
+	sudo := &Sudo{Password: "wrong"}
+	r, err := NewRemote(context.Background(), onUpdate, &transport.Local{}, sudo.Wrap("true"))
+	require.NoError(t, err)
+	require.NoError(t, r.EnableInput())
+	require.NoError(t, r.WriteInput(sudo.Input()))
+	runToCompletion(t, r)
+
+	assert.False(t, r.Successful())
+	assert.Equal(t, FailureSudo, r.Failure())
+}
+
+func TestSudoWrapScript(t *testing.T) {
+	// Shells such as dash read ahead of the running command on piped stdin.
+	for _, shell := range []string{"bash", "dash", "sh"} {
+		t.Run(shell, func(t *testing.T) {
+			if _, err := exec.LookPath(shell); err != nil {
+				t.Skip(shell + " not available")
+			}
+			installFakeSudo(t)
+
+			sudo := &Sudo{Password: "secret"}
+			prologue, cmds := sudo.WrapScript(
+				[]ScriptCmd{{Cmd: "echo one"}, {Cmd: "echo two", Timeout: 5e9}})
+			script, err := NewScript(cmds)
+			require.NoError(t, err)
+
+			r, err := NewRemoteScript(context.Background(), onUpdate, &transport.Local{}, shell,
+				"status", sudo.Secret(), prologue+script.String())
+			require.NoError(t, err)
+			runToCompletion(t, r)
+
+			require.True(t, r.Successful(), r.View())
+			assert.NotContains(t, r.View(), "secret")
+			out := script.FormatOutput(r.View(), false, func(s Section) string {
+				return s.Label + ":" + map[bool]string{true: "ok", false: "fail"}[s.Successful()]
+			})
+			assert.Contains(t, out, "echo one:okone\n")
+			assert.Contains(t, out, "echo two:oktwo\n")
+		})
+	}
+}
+
+func TestSudoCancel(t *testing.T) {
+	for _, prog := range []string{"setsid", "pgrep"} {
+		if _, err := exec.LookPath(prog); err != nil {
+			t.Skipf("%s not available: %v", prog, err)
+		}
+	}
+	installFakeSudo(t)
+	log := filepath.Join(t.TempDir(), "sudo.log")
+	t.Setenv("SUDO_LOG", log)
+
+	sudo := &Sudo{Password: "secret"}
+	r, err := NewRemote(context.Background(), onUpdate, &transport.Local{},
+		sudo.Wrap("sleep 30; echo done"))
+	require.NoError(t, err)
+	r.SetInput(sudo.Input())
+	r.SetSudo(sudo)
+	r.cancelGrace = time.Hour // Only the elevated remote kill may stop the command.
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		r.Cancel()
+	}()
+
+	start := time.Now()
+	runToCompletion(t, r)
+
+	assert.Less(t, time.Since(start), 10*time.Second)
+	assert.False(t, r.Successful())
+	assert.NotContains(t, r.View(), "done")
+
+	// The process group is owned by root, so the kill must also be elevated.
+	b, err := os.ReadFile(log)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[1], "-A -- sh -c kill -s INT"), "got %q", lines[1])
+}
+
+func TestSudoRequiresPassword(t *testing.T) {
+	installFakeSudo(t)
+	ctx := context.Background()
+
+	required, err := SudoRequiresPassword(ctx, &transport.Local{})
+	require.NoError(t, err)
+	assert.True(t, required)
+
+	t.Setenv("NOPASSWD", "1")
+	required, err = SudoRequiresPassword(ctx, &transport.Local{})
+	require.NoError(t, err)
+	assert.False(t, required)
+}
@@ -0,0 +1,146 @@
+// Package sshdest parses and renders SSH destinations, handling IPv6 addresses and custom ports
+// for the different forms accepted by ssh and nixos-rebuild.
+package sshdest
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Destination is an SSH target: an optional user, a host name or IP address, and an optional
+// port.
+type Destination struct {
+	User string
+	Host string // Host name or IP address, IPv6 addresses are stored without brackets.
+	Port int    // Zero for the default port.
+}
+
+// Parse parses an ssh://[user@]host[:port] URL, or [user@]host[:port].  IPv6 addresses must be
+// bracketed when followed by a port, ie `[fe80::1]:2222`; bare IPv6 addresses are accepted
+// without a port.
+func Parse(s string) (Destination, error) {
+	var d Destination
+	rest := strings.TrimPrefix(s, "ssh://")
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		d.User, rest = rest[:i], rest[i+1:]
+		if d.User == "" {
+			return d, fmt.Errorf("empty user in destination %q", s)
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(rest, "["):
+		end := strings.Index(rest, "]")
+		if end < 0 {
+			return d, fmt.Errorf("missing ']' in destination %q", s)
+		}
+		d.Host = rest[1:end]
+		if tail := rest[end+1:]; tail != "" {
+			if !strings.HasPrefix(tail, ":") {
+				return d, fmt.Errorf("unexpected %q after ']' in destination %q", tail, s)
+			}
+			port, err := parsePort(tail[1:])
+			if err != nil {
+				return d, fmt.Errorf("destination %q: %w", s, err)
+			}
+			d.Port = port
+		}
+
+	case strings.Count(rest, ":") > 1:
+		// Bare IPv6 address, a port cannot be specified without brackets.
+		d.Host = rest
+
+	default:
+		host, port, found := strings.Cut(rest, ":")
+		d.Host = host
+		if found {
+			p, err := parsePort(port)
+			if err != nil {
+				return d, fmt.Errorf("destination %q: %w", s, err)
+			}
+			d.Port = p
+		}
+	}
+
+	if d.Host == "" {
+		return d, fmt.Errorf("empty host in destination %q", s)
+	}
+
+	return d, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+
+	return port, nil
+}
+
+// IsIP returns true if the host is an IP address rather than a name.
+func (d Destination) IsIP() bool {
+	return net.ParseIP(d.Host) != nil
+}
+
+// URL returns the destination as an ssh:// URL.  IPv6 addresses are always bracketed, ssh
+// otherwise mistakes the address for a host and port.
+func (d Destination) URL() string {
+	host := d.HostPort()
+	if d.Port == 0 && strings.Contains(d.Host, ":") {
+		host = "[" + d.Host + "]"
+	}
+
+	return "ssh://" + d.userPrefix() + host
+}
+
+// HostPort returns the host with the port when set, ie `[fe80::1]:2222`.  IPv6 addresses are only
+// bracketed when a port is present.
+func (d Destination) HostPort() string {
+	if d.Port == 0 {
+		return d.Host
+	}
+
+	return net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+}
+
+// Addr returns the host:port network address, using the default SSH port when unset.
+func (d Destination) Addr() string {
+	port := d.Port
+	if port == 0 {
+		port = 22
+	}
+
+	return net.JoinHostPort(d.Host, strconv.Itoa(port))
+}
+
+// TargetHost returns the value of the nixos-rebuild --target-host flag, ie `user@host`.
+// nixos-rebuild passes it to ssh directly, which does not accept a port or brackets in this form;
+// the port must be passed via SSHOptions in NIX_SSHOPTS.
+func (d Destination) TargetHost() string {
+	return d.userPrefix() + d.Host
+}
+
+// SSHOptions returns the ssh options required by the TargetHost form, ie the port.
+func (d Destination) SSHOptions() []string {
+	if d.Port == 0 {
+		return nil
+	}
+
+	return []string{"-p", strconv.Itoa(d.Port)}
+}
+
+// String returns the destination for display, ie `user@[fe80::1]:2222`.
+func (d Destination) String() string {
+	return d.userPrefix() + d.HostPort()
+}
+
+func (d Destination) userPrefix() string {
+	if d.User == "" {
+		return ""
+	}
+
+	return d.User + "@"
+}
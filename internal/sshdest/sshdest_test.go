@@ -0,0 +1,130 @@
+package sshdest_test
+
+import (
+	"testing"
+
+	"github.com/jhillyerd/labcoat/internal/sshdest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tcs := []struct {
+		input string
+		want  sshdest.Destination
+	}{
+		{"example.com", sshdest.Destination{Host: "example.com"}},
+		{"root@example.com", sshdest.Destination{User: "root", Host: "example.com"}},
+		{"example.com:2222", sshdest.Destination{Host: "example.com", Port: 2222}},
+		{"root@10.0.0.1:2222", sshdest.Destination{User: "root", Host: "10.0.0.1", Port: 2222}},
+		{"fe80::1", sshdest.Destination{Host: "fe80::1"}},
+		{"root@2001:db8::1", sshdest.Destination{User: "root", Host: "2001:db8::1"}},
+		{"[fe80::1]", sshdest.Destination{Host: "fe80::1"}},
+		{"[2001:db8::1]:2222", sshdest.Destination{Host: "2001:db8::1", Port: 2222}},
+		{"ssh://root@[2001:db8::1]:22", sshdest.Destination{User: "root", Host: "2001:db8::1", Port: 22}},
+		{"ssh://deploy@example.com", sshdest.Destination{User: "deploy", Host: "example.com"}},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := sshdest.Parse(tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"@example.com",
+		"root@",
+		"example.com:",
+		"example.com:ssh",
+		"example.com:70000",
+		"[fe80::1",
+		"[fe80::1]2222",
+		"[]:22",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := sshdest.Parse(input)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	tcs := []struct {
+		name       string
+		dest       sshdest.Destination
+		url        string
+		targetHost string
+		sshOpts    []string
+		addr       string
+		str        string
+	}{
+		{
+			name:       "host",
+			dest:       sshdest.Destination{Host: "example.com"},
+			url:        "ssh://example.com",
+			targetHost: "example.com",
+			addr:       "example.com:22",
+			str:        "example.com",
+		},
+		{
+			name:       "user port",
+			dest:       sshdest.Destination{User: "root", Host: "example.com", Port: 2222},
+			url:        "ssh://root@example.com:2222",
+			targetHost: "root@example.com",
+			sshOpts:    []string{"-p", "2222"},
+			addr:       "example.com:2222",
+			str:        "root@example.com:2222",
+		},
+		{
+			name:       "ipv6",
+			dest:       sshdest.Destination{User: "root", Host: "2001:db8::1"},
+			url:        "ssh://root@[2001:db8::1]",
+			targetHost: "root@2001:db8::1",
+			addr:       "[2001:db8::1]:22",
+			str:        "root@2001:db8::1",
+		},
+		{
+			name:       "ipv6 port",
+			dest:       sshdest.Destination{User: "root", Host: "2001:db8::1", Port: 2222},
+			url:        "ssh://root@[2001:db8::1]:2222",
+			targetHost: "root@2001:db8::1",
+			sshOpts:    []string{"-p", "2222"},
+			addr:       "[2001:db8::1]:2222",
+			str:        "root@[2001:db8::1]:2222",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.url, tc.dest.URL())
+			assert.Equal(t, tc.targetHost, tc.dest.TargetHost())
+			assert.Equal(t, tc.sshOpts, tc.dest.SSHOptions())
+			assert.Equal(t, tc.addr, tc.dest.Addr())
+			assert.Equal(t, tc.str, tc.dest.String())
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, input := range []string{
+		"ssh://example.com",
+		"ssh://root@example.com:2222",
+		"ssh://root@[2001:db8::1]:2222",
+		"ssh://root@[2001:db8::1]",
+	} {
+		d, err := sshdest.Parse(input)
+		require.NoError(t, err)
+		assert.Equal(t, input, d.URL())
+	}
+}
+
+func TestIsIP(t *testing.T) {
+	assert.True(t, sshdest.Destination{Host: "10.0.0.1"}.IsIP())
+	assert.True(t, sshdest.Destination{Host: "fe80::1"}.IsIP())
+	assert.False(t, sshdest.Destination{Host: "web"}.IsIP())
+}
@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ControlMaster manages OpenSSH ControlMaster sockets in a private runtime directory, so that all
+// ssh invocations for a host share a single connection.
+type ControlMaster struct {
+	dir string
+
+	mu      sync.Mutex
+	masters map[string][]string // ssh arguments of destinations that may have a running master.
+}
+
+// NewControlMaster creates a private socket directory under $XDG_RUNTIME_DIR, falling back to the
+// system temp dir.
+func NewControlMaster() (*ControlMaster, error) {
+	root := os.Getenv("XDG_RUNTIME_DIR")
+	if root == "" {
+		root = os.TempDir()
+	}
+
+	// MkdirTemp creates the directory with 0700 permissions.
+	dir, err := os.MkdirTemp(root, "labcoat-ssh-")
+	if err != nil {
+		return nil, fmt.Errorf("ssh control dir: %w", err)
+	}
+
+	return &ControlMaster{dir: dir, masters: make(map[string][]string)}, nil
+}
+
+// Options returns the ssh options to share a master connection to dest, followed by extra.  All
+// options that affect the connection must be included in extra, as they are required to locate the
+// master when it is stopped.
+func (c *ControlMaster) Options(dest string, extra ...string) []string {
+	opts := append([]string{
+		"-oControlMaster=auto",
+		// %C is a hash of the connection parameters, keeping the socket path short.
+		"-oControlPath=" + filepath.Join(c.dir, "%C"),
+		// Masters expire on their own if labcoat exits without calling Close.
+		"-oControlPersist=10m",
+	}, extra...)
+
+	args := append(append([]string{}, opts...), dest)
+	c.mu.Lock()
+	c.masters[strings.Join(args, "\x00")] = args
+	c.mu.Unlock()
+
+	return opts
+}
+
+// Close stops all master connections and removes the socket directory.
+func (c *ControlMaster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sockets, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	if len(sockets) > 0 {
+		for _, args := range c.masters {
+			// Options must match those of the master, or %C will not locate its socket.
+			dest := args[len(args)-1]
+			exitArgs := append(append([]string{}, args[:len(args)-1]...), "-O", "exit", dest)
+			cmd := exec.Command("ssh", exitArgs...)
+			if output, err := cmd.CombinedOutput(); err != nil {
+				// Fails if there was no master for this destination.
+				slog.Debug("ssh master exit failed", "dest", dest, "err", err, "output", string(output))
+			}
+		}
+	}
+	c.masters = make(map[string][]string)
+
+	return os.RemoveAll(c.dir)
+}
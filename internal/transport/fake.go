@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	FakeCommand = iota
+	FakeScript
+	FakeInteractive
+)
+
+// FakeRequest describes a process started via a Fake transport.
+type FakeRequest struct {
+	Kind    int    // FakeCommand, FakeScript, or FakeInteractive.
+	Cmd     string // Joined command line for FakeCommand.
+	Shell   string // Requested shell for FakeScript.
+	Secret  bool   // FakeScript stdin begins with a secret line.
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+	Signals <-chan os.Signal // Receives signals sent to the process.
+}
+
+// FakeExitError may be returned by a Fake handler to report the exit code of a command.  Handlers
+// emulate connection failures by returning a ConnectError.
+type FakeExitError int
+
+func (e FakeExitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e FakeExitError) ExitCode() int { return int(e) }
+
+// Fake is a Transport for tests, which handles processes in-process with Handler.
+type Fake struct {
+	Dest    string
+	Handler func(ctx context.Context, req FakeRequest) error
+}
+
+// Command implements Transport.
+func (t *Fake) Command(ctx context.Context, prog string, args ...string) Process {
+	cmdline := strings.Join(append([]string{prog}, args...), " ")
+	return t.newProcess(ctx, FakeRequest{Kind: FakeCommand, Cmd: cmdline})
+}
+
+// Script implements Transport.
+func (t *Fake) Script(ctx context.Context, shell string, secret bool) Process {
+	return t.newProcess(ctx, FakeRequest{Kind: FakeScript, Shell: shell, Secret: secret})
+}
+
+// Interactive implements Transport.
+func (t *Fake) Interactive() Process {
+	return t.newProcess(context.Background(), FakeRequest{Kind: FakeInteractive})
+}
+
+// Destination implements Transport.
+func (t *Fake) Destination() string {
+	if t.Dest == "" {
+		return "fake"
+	}
+	return t.Dest
+}
+
+func (t *Fake) newProcess(ctx context.Context, req FakeRequest) *fakeProcess {
+	return &fakeProcess{ctx: ctx, handler: t.Handler, req: req, signals: make(chan os.Signal, 10)}
+}
+
+type fakeProcess struct {
+	ctx     context.Context
+	handler func(context.Context, FakeRequest) error
+	req     FakeRequest
+	signals chan os.Signal
+}
+
+func (p *fakeProcess) Run() error {
+	req := p.req
+	if req.Stdin == nil {
+		req.Stdin = strings.NewReader("")
+	}
+	if req.Stdout == nil {
+		req.Stdout = io.Discard
+	}
+	if req.Stderr == nil {
+		req.Stderr = io.Discard
+	}
+	req.Signals = p.signals
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+
+	return p.handler(p.ctx, req)
+}
+
+// Signal implements Process, signals are queued for the handler.
+func (p *fakeProcess) Signal(sig os.Signal) error {
+	select {
+	case p.signals <- sig:
+		return nil
+	default:
+		return errors.New("fake signal queue full")
+	}
+}
+
+func (p *fakeProcess) SetStdin(r io.Reader)  { p.req.Stdin = r }
+func (p *fakeProcess) SetStdout(w io.Writer) { p.req.Stdout = w }
+func (p *fakeProcess) SetStderr(w io.Writer) { p.req.Stderr = w }
@@ -0,0 +1,241 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kevinburke/ssh_config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// KnownHosts maintains a private known_hosts file containing host keys pinned from the flake, so
+// that a stale entry in the user's known_hosts does not break batch mode connections.
+type KnownHosts struct {
+	dir  string
+	path string
+
+	mu   sync.Mutex
+	keys map[string][]ssh.PublicKey // Pinned keys by host name.
+}
+
+// NewKnownHosts creates an empty known_hosts file in a private directory under $XDG_RUNTIME_DIR,
+// falling back to the system temp dir.
+func NewKnownHosts() (*KnownHosts, error) {
+	root := os.Getenv("XDG_RUNTIME_DIR")
+	if root == "" {
+		root = os.TempDir()
+	}
+
+	dir, err := os.MkdirTemp(root, "labcoat-known-hosts-")
+	if err != nil {
+		return nil, fmt.Errorf("known_hosts dir: %w", err)
+	}
+
+	k := &KnownHosts{
+		dir:  dir,
+		path: filepath.Join(dir, "known_hosts"),
+		keys: make(map[string][]ssh.PublicKey),
+	}
+	if err := k.write(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Pin replaces the expected keys for host.  Keys are in authorized_keys format, ie
+// "ssh-ed25519 AAAA... comment".
+func (k *KnownHosts) Pin(host string, keys []string) error {
+	parsed := make([]ssh.PublicKey, 0, len(keys))
+	for _, line := range keys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return fmt.Errorf("host key for %q: %w", host, err)
+		}
+		parsed = append(parsed, key)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if len(parsed) == 0 {
+		delete(k.keys, host)
+	} else {
+		k.keys[host] = parsed
+	}
+
+	return k.write()
+}
+
+// Pinned is true if keys have been pinned for host.
+func (k *KnownHosts) Pinned(host string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return len(k.keys[host]) > 0
+}
+
+// Lines returns the known_hosts lines for host on port, or nil if no keys are pinned.  A zero port
+// is the SSH default.
+func (k *KnownHosts) Lines(host string, port int) []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var lines []string
+	for _, key := range k.keys[host] {
+		lines = append(lines, knownhosts.Line([]string{hostAddr(host, port)}, key))
+	}
+
+	return lines
+}
+
+// Options returns the OpenSSH options to strictly verify host against its pinned keys, or nil if
+// no keys are pinned.
+func (k *KnownHosts) Options(host string) []string {
+	if !k.Pinned(host) {
+		return nil
+	}
+
+	return []string{
+		"-oUserKnownHostsFile=" + k.path,
+		"-oGlobalKnownHostsFile=/dev/null",
+		"-oStrictHostKeyChecking=yes",
+		"-oUpdateHostKeys=no",
+		// Look up keys by the deploy host, regardless of HostName mapping or port.
+		"-oHostKeyAlias=" + host,
+	}
+}
+
+// HostKeyCallback returns a callback that verifies host against its pinned keys, or nil if no keys
+// are pinned.
+func (k *KnownHosts) HostKeyCallback(host string) (ssh.HostKeyCallback, error) {
+	if !k.Pinned(host) {
+		return nil, nil
+	}
+
+	k.mu.Lock()
+	cb, err := knownhosts.New(k.path)
+	k.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Match OpenSSH HostKeyAlias behavior.
+	alias := net.JoinHostPort(host, "22")
+	return func(_ string, remote net.Addr, key ssh.PublicKey) error {
+		return cb(alias, remote, key)
+	}, nil
+}
+
+// UserMismatch is true if the user's known_hosts files contain keys for host, but none of them
+// match the pinned keys; typically because the host was reinstalled.  Entries for a non-default
+// port are stored separately by OpenSSH, ie as `[host]:2222`.
+func (k *KnownHosts) UserMismatch(host string, port int) (bool, error) {
+	k.mu.Lock()
+	pinned := k.keys[host]
+	k.mu.Unlock()
+	if len(pinned) == 0 {
+		return false, nil
+	}
+
+	cb, err := knownHostsCallback(host)
+	if err != nil {
+		return false, err
+	}
+
+	addr := hostAddr(host, port)
+	tcpAddr := &net.TCPAddr{IP: net.IPv4zero}
+	for _, key := range pinned {
+		var keyErr *knownhosts.KeyError
+		err := cb(addr, tcpAddr, key)
+		if err == nil || !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+			// Matched, or host unknown to the user.
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// UpdateUserKnownHosts removes all entries for host on port from the user's known_hosts file, then
+// appends lines.
+func UpdateUserKnownHosts(host string, port int, lines []string) error {
+	path := "~/.ssh/known_hosts"
+	if files := strings.Fields(ssh_config.Get(host, "UserKnownHostsFile")); len(files) > 0 {
+		path = files[0]
+	}
+	path = expandHome(path)
+
+	if _, err := os.Stat(path); err == nil {
+		// ssh-keygen handles hashed host names.
+		name := knownhosts.Normalize(hostAddr(host, port))
+		output, err := exec.Command("ssh-keygen", "-R", name, "-f", path).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ssh-keygen -R %s: %w: %s", name, err, strings.TrimSpace(string(output)))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// hostAddr returns the network address of host on port, defaulting to port 22.
+func hostAddr(host string, port int) string {
+	if port == 0 {
+		port = 22
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// Close removes the known_hosts file.
+func (k *KnownHosts) Close() error {
+	return os.RemoveAll(k.dir)
+}
+
+// write atomically replaces the known_hosts file.  Caller must hold the lock.
+func (k *KnownHosts) write() error {
+	hosts := make([]string, 0, len(k.keys))
+	for host := range k.keys {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var sb strings.Builder
+	sb.WriteString("# Generated by labcoat from host keys declared in the flake.\n")
+	for _, host := range hosts {
+		for _, key := range k.keys[host] {
+			sb.WriteString(knownhosts.Line([]string{host}, key) + "\n")
+		}
+	}
+
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0o600); err != nil {
+		return fmt.Errorf("known_hosts: %w", err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		return fmt.Errorf("known_hosts: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package transport_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/labcoat/internal/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	return key
+}
+
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " root@host"
+}
+
+func TestKnownHostsPin(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	kh, err := transport.NewKnownHosts()
+	require.NoError(t, err)
+	defer kh.Close()
+
+	pinned, other := newHostKey(t), newHostKey(t)
+	require.NoError(t, kh.Pin("host.example.com", []string{authorizedKey(pinned)}))
+
+	assert.True(t, kh.Pinned("host.example.com"))
+	assert.False(t, kh.Pinned("other.example.com"))
+	assert.Nil(t, kh.Options("other.example.com"))
+
+	opts := kh.Options("host.example.com")
+	assert.Contains(t, opts, "-oStrictHostKeyChecking=yes")
+	assert.Contains(t, opts, "-oHostKeyAlias=host.example.com")
+
+	// Known hosts file contains the pinned key.
+	var path string
+	for _, o := range opts {
+		if p, ok := strings.CutPrefix(o, "-oUserKnownHostsFile="); ok {
+			path = p
+		}
+	}
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), kh.Lines("host.example.com", 0)[0])
+	assert.True(t, strings.HasPrefix(kh.Lines("host.example.com", 2222)[0], "[host.example.com]:2222 "))
+
+	// Callback verifies against the alias, not the dialed address.
+	cb, err := kh.HostKeyCallback("host.example.com")
+	require.NoError(t, err)
+	require.NotNil(t, cb)
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 2222}
+	assert.NoError(t, cb("10.0.0.1:2222", remote, pinned))
+	assert.Error(t, cb("10.0.0.1:2222", remote, other))
+
+	// Unpinned hosts have no callback.
+	cb, err = kh.HostKeyCallback("other.example.com")
+	require.NoError(t, err)
+	assert.Nil(t, cb)
+
+	// Repinning replaces the keys.
+	require.NoError(t, kh.Pin("host.example.com", []string{authorizedKey(other)}))
+	cb, err = kh.HostKeyCallback("host.example.com")
+	require.NoError(t, err)
+	assert.NoError(t, cb("10.0.0.1:2222", remote, other))
+	assert.Error(t, cb("10.0.0.1:2222", remote, pinned))
+
+	// Clean up on close.
+	require.NoError(t, kh.Close())
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestKnownHostsPinInvalid(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	kh, err := transport.NewKnownHosts()
+	require.NoError(t, err)
+	defer kh.Close()
+
+	err = kh.Pin("host", []string{"ssh-ed25519 not-base64"})
+	assert.ErrorContains(t, err, `host key for "host"`)
+	assert.False(t, kh.Pinned("host"))
+}
@@ -0,0 +1,38 @@
+package transport
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// Local runs commands on the machine running labcoat.
+type Local struct{}
+
+// Command implements Transport.
+func (t *Local) Command(ctx context.Context, prog string, args ...string) Process {
+	// Match ssh, which joins the command line for interpretation by the remote shell.
+	cmdline := strings.Join(append([]string{prog}, args...), " ")
+
+	return NewExecProcess(ctx, "sh", "-c", cmdline)
+}
+
+// Script implements Transport.
+func (t *Local) Script(ctx context.Context, shell string, secret bool) Process {
+	return NewExecProcess(ctx, "sh", "-c", scriptLauncher(shell, secret))
+}
+
+// Interactive implements Transport.
+func (t *Local) Interactive() Process {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+	}
+
+	return NewExecProcess(context.Background(), shell)
+}
+
+// Destination implements Transport.
+func (t *Local) Destination() string {
+	return "local"
+}
@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"context"
+	"strings"
+)
+
+// Machinectl runs commands inside a local systemd-nspawn container registered with machined.
+type Machinectl struct {
+	Machine string
+}
+
+// Command implements Transport.
+func (t *Machinectl) Command(ctx context.Context, prog string, args ...string) Process {
+	cmdline := strings.Join(append([]string{prog}, args...), " ")
+
+	return NewExecProcess(ctx, "systemd-run", t.runArgs("sh", "-c", cmdline)...)
+}
+
+// Script implements Transport.
+func (t *Machinectl) Script(ctx context.Context, shell string, secret bool) Process {
+	launcher := scriptLauncher(shell, secret)
+	return NewExecProcess(ctx, "systemd-run", t.runArgs("sh", "-c", launcher)...)
+}
+
+// Interactive implements Transport.
+func (t *Machinectl) Interactive() Process {
+	return NewExecProcess(context.Background(), "machinectl", "shell", t.Machine)
+}
+
+// Destination implements Transport.
+func (t *Machinectl) Destination() string {
+	return "machine://" + t.Machine
+}
+
+// runArgs returns systemd-run arguments to run a command in the container, connected to our
+// stdin & stdout.
+func (t *Machinectl) runArgs(cmd ...string) []string {
+	args := []string{"--machine=" + t.Machine, "--quiet", "--pipe", "--wait", "--collect"}
+	return append(args, cmd...)
+}
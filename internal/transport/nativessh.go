@@ -0,0 +1,619 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jhillyerd/labcoat/internal/sshdest"
+	"github.com/kevinburke/ssh_config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	sshDialTimeout       = 15 * time.Second
+	sshKeepaliveInterval = 30 * time.Second
+)
+
+// OpenSSH default identity files, ssh_config only reports the legacy ~/.ssh/identity.
+var defaultIdentityFiles = []string{"~/.ssh/id_rsa", "~/.ssh/id_ecdsa", "~/.ssh/id_ed25519"}
+
+// NativeSSH runs commands using the built-in Go SSH client, reusing a single connection per host
+// from Pool.  Interactive sessions are delegated to the OpenSSH client.
+type NativeSSH struct {
+	Pool     *SSHPool
+	User     string
+	Host     string   // Host name or IP address, without brackets.
+	Port     int      // Zero for the port from ~/.ssh/config, or the default.
+	JumpHost string   // Optional comma separated [user@]host[:port] list, as for ProxyJump.
+	Options  []string // Additional OpenSSH options for interactive sessions.
+}
+
+// Command implements Transport.
+func (t *NativeSSH) Command(ctx context.Context, prog string, args ...string) Process {
+	cmdline := strings.Join(append([]string{prog}, args...), " ")
+	return t.newProcess(ctx, cmdline)
+}
+
+// Script implements Transport.
+func (t *NativeSSH) Script(ctx context.Context, shell string, secret bool) Process {
+	return t.newProcess(ctx, scriptCmdline(shell, secret))
+}
+
+// Interactive implements Transport.
+func (t *NativeSSH) Interactive() Process {
+	opts := append([]string{}, t.Options...)
+	if t.JumpHost != "" {
+		opts = append(opts, "-oProxyJump="+t.JumpHost)
+	}
+
+	return (&OpenSSH{User: t.User, Host: t.Host, Port: t.Port, Options: opts}).Interactive()
+}
+
+// Destination returns the SSH URL of the target.
+func (t *NativeSSH) Destination() string {
+	return (&OpenSSH{User: t.User, Host: t.Host, Port: t.Port}).Destination()
+}
+
+func (t *NativeSSH) newProcess(ctx context.Context, cmdline string) *nativeProcess {
+	var jumps []string
+	if t.JumpHost != "" {
+		jumps = strings.Split(t.JumpHost, ",")
+	}
+
+	return &nativeProcess{
+		ctx:   ctx,
+		pool:  t.Pool,
+		user:  t.User,
+		host:  sshdest.Destination{Host: t.Host, Port: t.Port}.HostPort(),
+		jumps: jumps,
+		cmd:   cmdline,
+	}
+}
+
+// SSHPool maintains one multiplexed SSH connection per destination for the lifetime of labcoat.
+type SSHPool struct {
+	// ClientConfig resolves the network address and client configuration for a destination.
+	// Defaults to using ssh-agent, ~/.ssh/config and known_hosts.
+	ClientConfig func(user, host string) (addr string, conf *ssh.ClientConfig, err error)
+
+	// KnownHosts, if set, overrides host key verification for hosts with pinned keys.
+	KnownHosts *KnownHosts
+
+	mu      sync.Mutex
+	clients map[string]*ssh.Client
+
+	agentMu     sync.Mutex
+	agentConn   net.Conn            // Connection to ssh-agent, nil until required.
+	agentClient agent.ExtendedAgent // Shared by handshakes, serializing requests on agentConn.
+}
+
+// NewSSHPool constructs an empty connection pool.
+func NewSSHPool() *SSHPool {
+	p := &SSHPool{clients: make(map[string]*ssh.Client)}
+	p.ClientConfig = p.sshClientConfig
+
+	return p
+}
+
+// Close disconnects all pooled connections.
+func (p *SSHPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for key, client := range p.clients {
+		errs = append(errs, client.Close())
+		delete(p.clients, key)
+	}
+	p.closeAgent()
+
+	return errors.Join(errs...)
+}
+
+// session opens a new session on the pooled connection for the destination, connecting as
+// required.  A failed pooled connection is likely stale, and is replaced once.
+func (p *SSHPool) session(
+	ctx context.Context, user, host string, jumps []string,
+) (*ssh.Session, error) {
+	key := poolKey(user, host, jumps)
+	for attempt := 0; ; attempt++ {
+		client, pooled, err := p.client(ctx, user, host, jumps)
+		if err != nil {
+			return nil, err
+		}
+
+		session, err := newSession(ctx, client)
+		if err == nil {
+			return session, nil
+		}
+
+		// Connection is likely dead, discard and reconnect.
+		p.discard(key, client)
+		if !pooled || attempt > 0 || ctx.Err() != nil {
+			return nil, err
+		}
+		slog.Debug("SSH session failed, reconnecting", "dest", key, "err", err)
+	}
+}
+
+// newSession opens a session on client, giving up once ctx is done.  NewSession is not context
+// aware, and blocks on a half-dead connection until the server replies.
+func newSession(ctx context.Context, client *ssh.Client) (*ssh.Session, error) {
+	type result struct {
+		session *ssh.Session
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		session, err := client.NewSession()
+		done <- result{session, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.session, r.err
+
+	case <-ctx.Done():
+		go func() {
+			// Close a session opened after all, once the caller discards the client.
+			if r := <-done; r.session != nil {
+				_ = r.session.Close()
+			}
+		}()
+		return nil, fmt.Errorf("ssh: open session: %w", ctx.Err())
+	}
+}
+
+// client returns the connection to the destination, reached via the chain of jump hosts.  pooled
+// is true if the connection was established by an earlier call.
+func (p *SSHPool) client(
+	ctx context.Context, user, host string, jumps []string,
+) (client *ssh.Client, pooled bool, err error) {
+	key := poolKey(user, host, jumps)
+
+	p.mu.Lock()
+	client = p.clients[key]
+	p.mu.Unlock()
+	if client != nil {
+		return client, true, nil
+	}
+
+	var via *ssh.Client
+	if len(jumps) > 0 {
+		// Connect to the last jump host via those preceding it.
+		last := len(jumps) - 1
+		juser, jhost := splitJumpHost(jumps[last])
+
+		if via, _, err = p.client(ctx, juser, jhost, jumps[:last]); err != nil {
+			return nil, false, fmt.Errorf("ssh: jump host %s: %w", jumps[last], err)
+		}
+	}
+
+	client, err = p.dial(ctx, user, host, via)
+	if err != nil {
+		if via != nil {
+			// The jump connection may be dead, force a reconnect next attempt.
+			p.discard(poolKey(splitJumpHostKey(jumps)), via)
+		}
+		return nil, false, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if existing := p.clients[key]; existing != nil {
+		// Lost a race with another dial, prefer the existing connection.
+		_ = client.Close()
+		return existing, false, nil
+	}
+	p.clients[key] = client
+	go p.keepalive(key, client)
+
+	return client, false, nil
+}
+
+// keepalive probes the pooled client every sshKeepaliveInterval, as OpenSSH ServerAliveInterval
+// does.  Clients that fail to reply in time are discarded, so the next session reconnects rather
+// than waiting on a dead connection.  Returns once client is closed.
+func (p *SSHPool) keepalive(key string, client *ssh.Client) {
+	ticker := time.NewTicker(sshKeepaliveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		reply := make(chan error, 1)
+		go func() {
+			// Servers reject the unknown request, any reply shows the connection is alive.
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+
+		select {
+		case err := <-reply:
+			if err == nil {
+				continue
+			}
+		case <-time.After(sshKeepaliveInterval):
+			slog.Debug("SSH keepalive timed out, disconnecting", "dest", key)
+		}
+		p.discard(key, client)
+		return
+	}
+}
+
+// dial connects to the destination, directly or through an established jump host connection.  The
+// host may include a port, overriding ssh_config.
+func (p *SSHPool) dial(
+	ctx context.Context, user, host string, via *ssh.Client,
+) (*ssh.Client, error) {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = host, ""
+	}
+
+	addr, conf, err := p.ClientConfig(user, name)
+	if err != nil {
+		return nil, err
+	}
+	if port != "" {
+		if h, _, err := net.SplitHostPort(addr); err == nil {
+			addr = net.JoinHostPort(h, port)
+		}
+	}
+	if p.KnownHosts != nil {
+		cb, err := p.KnownHosts.HostKeyCallback(name)
+		if err != nil {
+			return nil, err
+		}
+		if cb != nil {
+			conf.HostKeyCallback = cb
+			conf.HostKeyAlgorithms = hostKeyAlgorithms(cb, addr)
+		}
+	}
+
+	slog.Debug("SSH connecting", "addr", addr, "user", conf.User, "via", via != nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sshDialTimeout)
+	defer cancel()
+
+	var conn net.Conn
+	if via != nil {
+		conn, err = via.DialContext(ctx, "tcp", addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ssh: connect to %s: %w", addr, err)
+	}
+
+	// Handshake is not context aware, use a deadline instead.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, conf)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("ssh: handshake with %s: %w", addr, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// poolKey identifies a connection in the pool.
+func poolKey(user, host string, jumps []string) string {
+	key := user + "@" + host
+	if len(jumps) > 0 {
+		key += " via " + strings.Join(jumps, ",")
+	}
+
+	return key
+}
+
+// splitJumpHostKey returns the poolKey arguments for the last jump host of the chain.
+func splitJumpHostKey(jumps []string) (string, string, []string) {
+	last := len(jumps) - 1
+	user, host := splitJumpHost(jumps[last])
+
+	return user, host, jumps[:last]
+}
+
+// splitJumpHost splits a [ssh://][user@]host[:port] jump host spec.
+func splitJumpHost(spec string) (user, host string) {
+	spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+	if i := strings.LastIndexByte(spec, '@'); i != -1 {
+		return spec[:i], spec[i+1:]
+	}
+
+	return "", spec
+}
+
+func (p *SSHPool) discard(key string, client *ssh.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.clients[key] == client {
+		delete(p.clients, key)
+	}
+	_ = client.Close()
+}
+
+type nativeProcess struct {
+	mu      sync.Mutex
+	session *ssh.Session // Set while running.
+
+	ctx    context.Context
+	pool   *SSHPool
+	user   string
+	host   string
+	jumps  []string
+	cmd    string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (p *nativeProcess) Run() error {
+	session, err := p.pool.session(p.ctx, p.user, p.host, p.jumps)
+	if err != nil {
+		return &ConnectError{Err: err}
+	}
+	defer session.Close()
+
+	session.Stdout = p.stdout
+	session.Stderr = p.stderr
+
+	if p.stdin != nil {
+		// Copy stdin ourselves; session.Wait would otherwise block until stdin reaches EOF, even
+		// after the remote command has exited.
+		w, err := session.StdinPipe()
+		if err != nil {
+			return err
+		}
+		go func() {
+			_, _ = io.Copy(w, p.stdin)
+			_ = w.Close()
+		}()
+	}
+
+	if err := session.Start(p.cmd); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.session = session
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.session = nil
+		p.mu.Unlock()
+	}()
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+
+	case <-p.ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+		return p.ctx.Err()
+	}
+}
+
+// Signal delivers sig to the remote process, if supported by the server.
+func (p *nativeProcess) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.session == nil {
+		return errNotStarted
+	}
+
+	var ssig ssh.Signal
+	switch sig {
+	case syscall.SIGINT:
+		ssig = ssh.SIGINT
+	case syscall.SIGTERM:
+		ssig = ssh.SIGTERM
+	case syscall.SIGKILL:
+		ssig = ssh.SIGKILL
+	case syscall.SIGHUP:
+		ssig = ssh.SIGHUP
+	default:
+		return fmt.Errorf("unsupported signal: %v", sig)
+	}
+
+	return p.session.Signal(ssig)
+}
+
+func (p *nativeProcess) SetStdin(r io.Reader)  { p.stdin = r }
+func (p *nativeProcess) SetStdout(w io.Writer) { p.stdout = w }
+func (p *nativeProcess) SetStderr(w io.Writer) { p.stderr = w }
+
+// sshClientConfig resolves the destination using ~/.ssh/config, authenticating with ssh-agent
+// and unencrypted identity files, and verifying host keys against known_hosts.
+func (p *SSHPool) sshClientConfig(username, host string) (string, *ssh.ClientConfig, error) {
+	hostname := ssh_config.Get(host, "HostName")
+	if hostname == "" {
+		hostname = host
+	}
+	port := ssh_config.Get(host, "Port")
+	if port == "" {
+		port = "22"
+	}
+	addr := net.JoinHostPort(hostname, port)
+
+	if username == "" {
+		username = ssh_config.Get(host, "User")
+	}
+	if username == "" {
+		if u, err := user.Current(); err == nil {
+			username = u.Username
+		}
+	}
+
+	hostKeyCallback, err := knownHostsCallback(host)
+	if err != nil {
+		return "", nil, err
+	}
+
+	conf := &ssh.ClientConfig{
+		User:              username,
+		Auth:              []ssh.AuthMethod{ssh.PublicKeysCallback(p.signers(host))},
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms(hostKeyCallback, addr),
+		Timeout:           sshDialTimeout,
+	}
+
+	return addr, conf, nil
+}
+
+// signers returns a callback listing ssh-agent keys followed by unencrypted identity files.
+func (p *SSHPool) signers(host string) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		var result []ssh.Signer
+
+		if s, err := p.agentSigners(); err == nil {
+			result = append(result, s...)
+		} else {
+			slog.Debug("ssh-agent unavailable", "err", err)
+		}
+
+		paths := append(ssh_config.GetAll(host, "IdentityFile"), defaultIdentityFiles...)
+		for _, path := range paths {
+			b, err := os.ReadFile(expandHome(path))
+			if err != nil {
+				continue
+			}
+			signer, err := ssh.ParsePrivateKey(b)
+			if err != nil {
+				// Likely passphrase protected, ssh-agent should be used for those.
+				slog.Debug("Skipping SSH identity", "path", path, "err", err)
+				continue
+			}
+			result = append(result, signer)
+		}
+
+		return result, nil
+	}
+}
+
+// agentSigners lists the keys held by ssh-agent, if SSH_AUTH_SOCK is set.  The signers use the
+// agent connection, so it remains open until the pool is closed, or the agent fails.
+func (p *SSHPool) agentSigners() ([]ssh.Signer, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil
+	}
+
+	p.agentMu.Lock()
+	defer p.agentMu.Unlock()
+
+	if p.agentConn == nil {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, err
+		}
+		p.agentConn = conn
+		p.agentClient = agent.NewClient(conn)
+	}
+
+	signers, err := p.agentClient.Signers()
+	if err != nil {
+		// Agent may have restarted, reconnect next time.
+		_ = p.agentConn.Close()
+		p.agentConn, p.agentClient = nil, nil
+		return nil, err
+	}
+
+	return signers, nil
+}
+
+// closeAgent closes the ssh-agent connection, if open.
+func (p *SSHPool) closeAgent() {
+	p.agentMu.Lock()
+	defer p.agentMu.Unlock()
+
+	if p.agentConn != nil {
+		_ = p.agentConn.Close()
+		p.agentConn, p.agentClient = nil, nil
+	}
+}
+
+// knownHostsCallback verifies host keys against the user and global known_hosts files.
+func knownHostsCallback(host string) (ssh.HostKeyCallback, error) {
+	files := strings.Fields(ssh_config.Get(host, "UserKnownHostsFile"))
+	files = append(files, strings.Fields(ssh_config.Get(host, "GlobalKnownHostsFile"))...)
+
+	var existing []string
+	for _, f := range files {
+		f = expandHome(f)
+		if _, err := os.Stat(f); err == nil {
+			existing = append(existing, f)
+		}
+	}
+
+	cb, err := knownhosts.New(existing...)
+	if err != nil {
+		return nil, fmt.Errorf("ssh: reading known_hosts: %w", err)
+	}
+
+	return cb, nil
+}
+
+// hostKeyAlgorithms returns the algorithms of keys recorded for addr in known_hosts, so that the
+// server does not offer a key type that is not known to us.
+func hostKeyAlgorithms(cb ssh.HostKeyCallback, addr string) []string {
+	// Probe the callback with a placeholder key, the resulting error lists known keys.
+	tcpAddr := &net.TCPAddr{IP: net.IPv4zero}
+	err := cb(addr, tcpAddr, placeholderKey{})
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algos []string
+	for _, known := range keyErr.Want {
+		switch known.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algos = append(algos, known.Key.Type())
+		}
+	}
+
+	return algos
+}
+
+// placeholderKey is a public key that will never match a known host.
+type placeholderKey struct{}
+
+func (placeholderKey) Type() string                            { return "placeholder" }
+func (placeholderKey) Marshal() []byte                         { return []byte("placeholder") }
+func (placeholderKey) Verify(_ []byte, _ *ssh.Signature) error { return errors.New("placeholder") }
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+
+	return path
+}
@@ -0,0 +1,367 @@
+package transport_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/labcoat/internal/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startSSHServer starts an SSH server that echos exec commands and their stdin back to the client.
+// It returns the listen address and a pointer to the count of accepted connections.
+func startSSHServer(t *testing.T) (string, *int32) {
+	t.Helper()
+
+	return startSSHServerConf(t, &ssh.ServerConfig{NoClientAuth: true})
+}
+
+// startSSHServerConf is startSSHServer authenticating clients with conf, which is given a host key.
+func startSSHServerConf(t *testing.T, conf *ssh.ServerConfig) (string, *int32) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	conf.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	var conns int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&conns, 1)
+			go serveSSHConn(conn, conf)
+		}
+	}()
+
+	return l.Addr().String(), &conns
+}
+
+func serveSSHConn(conn net.Conn, conf *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, conf)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() == "direct-tcpip" {
+			go forwardSSHChannel(nc)
+			continue
+		}
+
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			for req := range reqs {
+				if req.Type != "exec" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				_ = req.Reply(true, nil)
+
+				cmdLen := binary.BigEndian.Uint32(req.Payload)
+				cmd := string(req.Payload[4 : 4+cmdLen])
+				_, _ = io.WriteString(ch, "ran: "+cmd+"\n")
+				if strings.HasPrefix(cmd, "sh -c ") {
+					_, _ = io.Copy(ch, ch)
+				}
+
+				_, _ = ch.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
+				_ = ch.Close()
+			}
+		}()
+	}
+}
+
+// forwardSSHChannel handles a direct-tcpip channel, as used by jump hosts.
+func forwardSSHChannel(nc ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OrigHost   string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &target); err != nil {
+		_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	go func() {
+		_, _ = io.Copy(conn, ch)
+		_ = conn.Close()
+	}()
+	_, _ = io.Copy(ch, conn)
+	_ = ch.Close()
+}
+
+func TestNativeSSHConnectionReuse(t *testing.T) {
+	addr, conns := startSSHServer(t)
+
+	pool := transport.NewSSHPool()
+	pool.ClientConfig = func(user, host string) (string, *ssh.ClientConfig, error) {
+		return addr, &ssh.ClientConfig{
+			User:            user,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		}, nil
+	}
+	defer pool.Close()
+
+	native := &transport.NativeSSH{Pool: pool, User: "root", Host: "example.com"}
+	assert.Equal(t, "ssh://root@example.com", native.Destination())
+
+	ctx := context.Background()
+	for _, cmd := range []string{"uptime", "uname -a"} {
+		var out bytes.Buffer
+		proc := native.Command(ctx, cmd)
+		proc.SetStdout(&out)
+		require.NoError(t, proc.Run())
+		assert.Equal(t, "ran: "+cmd+"\n", out.String())
+	}
+
+	var out bytes.Buffer
+	proc := native.Script(ctx, "", false)
+	proc.SetStdin(bytes.NewBufferString("date\n"))
+	proc.SetStdout(&out)
+	require.NoError(t, proc.Run())
+	assert.True(t, strings.HasPrefix(out.String(), "ran: sh -c "), "got %q", out.String())
+	assert.True(t, strings.HasSuffix(out.String(), "\ndate\n"), "got %q", out.String())
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(conns), "Connection should be reused")
+}
+
+func TestNativeSSHReconnect(t *testing.T) {
+	addr, conns := startSSHServer(t)
+
+	pool := transport.NewSSHPool()
+	pool.ClientConfig = func(user, host string) (string, *ssh.ClientConfig, error) {
+		return addr, &ssh.ClientConfig{
+			User:            user,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		}, nil
+	}
+	defer pool.Close()
+
+	native := &transport.NativeSSH{Pool: pool, Host: "example.com"}
+	ctx := context.Background()
+
+	require.NoError(t, native.Command(ctx, "true").Run())
+
+	// Closing the pool drops connections, the next command must reconnect.
+	require.NoError(t, pool.Close())
+	require.NoError(t, native.Command(ctx, "true").Run())
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(conns))
+}
+
+func TestNativeSSHAgentConnection(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keyring := agent.NewKeyring()
+	require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: key}))
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	// Serve the key from an agent, counting its connections.
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	var agentConns int32
+	closed := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&agentConns, 1)
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				closed <- struct{}{}
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	// Server only accepts the agent key, which must sign during the handshake.
+	addr, conns := startSSHServerConf(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, k ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(k.Marshal(), signer.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	})
+
+	pool := transport.NewSSHPool()
+	defaults := pool.ClientConfig
+	pool.ClientConfig = func(user, host string) (string, *ssh.ClientConfig, error) {
+		_, conf, err := defaults(user, host)
+		if err != nil {
+			return "", nil, err
+		}
+		conf.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		conf.HostKeyAlgorithms = nil
+		return addr, conf, nil
+	}
+
+	ctx := context.Background()
+	for _, user := range []string{"alice", "bob"} {
+		native := &transport.NativeSSH{Pool: pool, User: user, Host: "example.com"}
+		require.NoError(t, native.Command(ctx, "true").Run())
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(conns))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&agentConns), "Agent connection should be shared")
+
+	require.NoError(t, pool.Close())
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Closing the pool should close the agent connection")
+	}
+}
+
+func TestNativeSSHJumpHost(t *testing.T) {
+	bastionAddr, bastionConns := startSSHServer(t)
+	targetAddr, targetConns := startSSHServer(t)
+
+	var users []string
+	pool := transport.NewSSHPool()
+	pool.ClientConfig = func(user, host string) (string, *ssh.ClientConfig, error) {
+		addrs := map[string]string{"bastion": bastionAddr, "target": targetAddr}
+		users = append(users, user+"@"+host)
+		return addrs[host], &ssh.ClientConfig{
+			User:            user,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		}, nil
+	}
+	defer pool.Close()
+
+	native := &transport.NativeSSH{Pool: pool, User: "root", Host: "target", JumpHost: "jump@bastion"}
+	ctx := context.Background()
+	for _, cmd := range []string{"uptime", "uname -a"} {
+		var out bytes.Buffer
+		proc := native.Command(ctx, cmd)
+		proc.SetStdout(&out)
+		require.NoError(t, proc.Run())
+		assert.Equal(t, "ran: "+cmd+"\n", out.String())
+	}
+
+	assert.Equal(t, []string{"jump@bastion", "root@target"}, users)
+	assert.Equal(t, int32(1), atomic.LoadInt32(bastionConns), "Bastion connection should be reused")
+	assert.Equal(t, int32(1), atomic.LoadInt32(targetConns), "Target connection should be reused")
+
+	// Interactive sessions pass the jump host to OpenSSH.
+	assert.Contains(t, args(t, native.Interactive()), "-oProxyJump=jump@bastion")
+}
+
+func TestNativeSSHDialNotRetried(t *testing.T) {
+	// Nothing listens on a closed listener's address.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	var dials int32
+	pool := transport.NewSSHPool()
+	pool.ClientConfig = func(user, host string) (string, *ssh.ClientConfig, error) {
+		atomic.AddInt32(&dials, 1)
+		return addr, &ssh.ClientConfig{User: user, HostKeyCallback: ssh.InsecureIgnoreHostKey()}, nil
+	}
+	defer pool.Close()
+
+	native := &transport.NativeSSH{Pool: pool, Host: "example.com"}
+	err = native.Command(context.Background(), "true").Run()
+	assert.True(t, transport.ConnectionFailed(err), "got %v", err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dials), "Failed dial should not be retried")
+}
+
+func TestNativeSSHSessionTimeout(t *testing.T) {
+	// Server completes the handshake, but never answers session requests.
+	conf := &ssh.ServerConfig{NoClientAuth: true}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	conf.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	var conns int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&conns, 1)
+			go func() {
+				_, _, reqs, err := ssh.NewServerConn(conn, conf)
+				if err == nil {
+					ssh.DiscardRequests(reqs)
+				}
+			}()
+		}
+	}()
+
+	pool := transport.NewSSHPool()
+	pool.ClientConfig = func(user, host string) (string, *ssh.ClientConfig, error) {
+		return l.Addr().String(),
+			&ssh.ClientConfig{User: user, HostKeyCallback: ssh.InsecureIgnoreHostKey()}, nil
+	}
+	defer pool.Close()
+	native := &transport.NativeSSH{Pool: pool, Host: "example.com"}
+
+	for range 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		start := time.Now()
+		err := native.Command(ctx, "true").Run()
+		cancel()
+
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.True(t, transport.ConnectionFailed(err))
+		assert.Less(t, time.Since(start), 5*time.Second)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&conns), "Unresponsive connection should be dropped")
+}
@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jhillyerd/labcoat/internal/sshdest"
+)
+
+// OpenSSH runs commands via the OpenSSH `ssh` client.
+type OpenSSH struct {
+	User    string
+	Host    string   // Host name or IP address, without brackets.
+	Port    int      // Zero for the default port.
+	Options []string // Additional ssh arguments, ie from ControlMaster.Options.
+}
+
+// Command implements Transport.
+func (t *OpenSSH) Command(ctx context.Context, prog string, args ...string) Process {
+	sshArgs := append(t.batchArgs(), prog)
+	sshArgs = append(sshArgs, args...)
+
+	return newSSHProcess(ctx, sshArgs...)
+}
+
+// Script implements Transport.
+func (t *OpenSSH) Script(ctx context.Context, shell string, secret bool) Process {
+	sshArgs := append(t.batchArgs(), scriptCmdline(shell, secret))
+
+	return newSSHProcess(ctx, sshArgs...)
+}
+
+// Interactive implements Transport.
+func (t *OpenSSH) Interactive() Process {
+	args := append(append([]string{}, t.Options...), t.Destination())
+	return NewExecProcess(context.Background(), "ssh", args...)
+}
+
+// newSSHProcess constructs a process for the ssh client, which exits with 255 when it fails to
+// connect.  Remote commands exiting with 255 are indistinguishable, but rare.
+func newSSHProcess(ctx context.Context, args ...string) *ExecProcess {
+	p := NewExecProcess(ctx, "ssh", args...)
+	p.connectExitCode = sshFailedExitCode
+
+	return p
+}
+
+// NixSSHOpts returns the value of NIX_SSHOPTS for nix commands to use the same ssh options.  Nix
+// tools connect to the TargetHost form of the destination, so the port is included.
+func (t *OpenSSH) NixSSHOpts() string {
+	return strings.Join(append(t.batchOptions(), t.dest().SSHOptions()...), " ")
+}
+
+// Destination returns the SSH URL of the target.
+func (t *OpenSSH) Destination() string {
+	return t.dest().URL()
+}
+
+func (t *OpenSSH) dest() sshdest.Destination {
+	return sshdest.Destination{User: t.User, Host: t.Host, Port: t.Port}
+}
+
+// batchArgs returns the ssh arguments for non-interactive use, ending with the destination.
+func (t *OpenSSH) batchArgs() []string {
+	return append(t.batchOptions(), t.Destination())
+}
+
+// batchOptions returns the ssh options for non-interactive use.
+func (t *OpenSSH) batchOptions() []string {
+	return append([]string{"-T", "-oBatchMode=yes"}, t.Options...)
+}
@@ -0,0 +1,174 @@
+// Package transport executes commands on target hosts.
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Transport runs commands on a particular target host.
+type Transport interface {
+	// Command returns a process that runs prog with args on the target.  As with ssh, the
+	// program and arguments are joined and interpreted by the target's shell.
+	Command(ctx context.Context, prog string, args ...string) Process
+
+	// Script returns a process that runs a POSIX sh compatible script read from its stdin on the
+	// target, interpreted by shell if installed, otherwise sh.  An empty shell selects
+	// DefaultScriptShell.  When secret is true, the first line of stdin is exported to the script
+	// as $_lc_secret instead, see scriptLauncher.
+	Script(ctx context.Context, shell string, secret bool) Process
+
+	// Interactive returns a process for an interactive login session on the target.
+	Interactive() Process
+
+	// Destination describes the target for display, ie an SSH URL or `local`.
+	Destination() string
+}
+
+// Process is a command started via a Transport.  It is compatible with tea.ExecCommand.
+type Process interface {
+	Run() error
+	SetStdin(io.Reader)
+	SetStdout(io.Writer)
+	SetStderr(io.Writer)
+
+	// Signal sends a signal to the running process.  For remote processes, this may only reach
+	// the local client.
+	Signal(os.Signal) error
+}
+
+// DefaultScriptShell interprets scripts when no shell is configured for the target.
+const DefaultScriptShell = "bash"
+
+var errNotStarted = errors.New("process not started")
+
+// OpenSSH exits with this status when the connection to the host fails.
+const sshFailedExitCode = 255
+
+// ConnectError is returned by processes that could not connect to, or authenticate with, the
+// target host.
+type ConnectError struct {
+	Err error
+}
+
+func (e *ConnectError) Error() string { return e.Err.Error() }
+func (e *ConnectError) Unwrap() error { return e.Err }
+
+// ConnectionFailed is true if err indicates the transport failed to connect to the target, rather
+// than the remote command failing.
+func ConnectionFailed(err error) bool {
+	var connErr *ConnectError
+
+	return errors.As(err, &connErr)
+}
+
+// scriptLauncher returns a sh program that runs a script read from stdin with shell, falling back
+// to sh when shell is missing, ie on rescue systems and installer images.  The interpreter is
+// exported as $_lc_sh for use by the script.
+//
+// When secret is true, the launcher reads the first line of stdin into $_lc_secret.  The script
+// cannot read it, as interpreters such as dash read ahead of the command being run on piped input.
+func scriptLauncher(shell string, secret bool) string {
+	if shell == "" {
+		shell = DefaultScriptShell
+	}
+
+	var read string
+	if secret {
+		read = "IFS= read -r _lc_secret; export _lc_secret; "
+	}
+
+	return read + "_lc_sh=" + shellQuote(shell) + "; " +
+		`command -v "$_lc_sh" >/dev/null 2>&1 || ` +
+		`{ echo "labcoat: $_lc_sh not found, using sh" >&2; _lc_sh=sh; }; ` +
+		`export _lc_sh; exec "$_lc_sh" -s`
+}
+
+// scriptCmdline returns the script launcher as a command line for the target's login shell.
+func scriptCmdline(shell string, secret bool) string {
+	return ShCmdline(scriptLauncher(shell, secret))
+}
+
+// ShCmdline returns a command line that runs script with sh, for the target's login shell, which
+// may not be POSIX compatible, ie fish.  Single quotes and backslashes are written within double
+// quotes, which both sh and fish interpret alike.
+func ShCmdline(script string) string {
+	quoted := strings.NewReplacer(`'`, `'"'"'`, `\`, `'"\\"'`).Replace(script)
+
+	return "sh -c '" + quoted + "'"
+}
+
+// shellQuote quotes s for use as a single sh word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ExecProcess is a Process backed by a local exec.Cmd.
+type ExecProcess struct {
+	*exec.Cmd
+	mu sync.Mutex // Guards Cmd.Process while starting.
+
+	// Exit code of the program reporting that it failed to connect, ie 255 for ssh; wrapped in a
+	// ConnectError.  Zero if the program does not connect anywhere.
+	connectExitCode int
+}
+
+// NewExecProcess constructs a process for a local program.
+func NewExecProcess(ctx context.Context, prog string, args ...string) *ExecProcess {
+	return &ExecProcess{Cmd: exec.CommandContext(ctx, prog, args...)}
+}
+
+// Run implements Process.
+func (p *ExecProcess) Run() error {
+	p.mu.Lock()
+	err := p.Start()
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = p.Wait()
+	var exitErr *exec.ExitError
+	if p.connectExitCode != 0 && errors.As(err, &exitErr) &&
+		exitErr.ExitCode() == p.connectExitCode {
+		return &ConnectError{Err: err}
+	}
+
+	return err
+}
+
+// SetStdin implements Process.
+func (p *ExecProcess) SetStdin(r io.Reader) { p.Stdin = r }
+
+// SetStdout implements Process.
+func (p *ExecProcess) SetStdout(w io.Writer) { p.Stdout = w }
+
+// SetStderr implements Process.
+func (p *ExecProcess) SetStderr(w io.Writer) { p.Stderr = w }
+
+// Signal implements Process.
+func (p *ExecProcess) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Process == nil {
+		return errNotStarted
+	}
+	return p.Process.Signal(sig)
+}
+
+// SetEnv appends an environment variable definition.  Due to the way `exec.Cmd` works, the first
+// call to this effectively stops the parent environment from being passed to the child process.
+func (p *ExecProcess) SetEnv(name string, value string) {
+	p.Env = append(p.Env, name+"="+value)
+}
+
+// String returns the command line.
+func (p *ExecProcess) String() string {
+	return strings.Join(p.Args, " ")
+}
@@ -0,0 +1,261 @@
+package transport_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/labcoat/internal/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func args(t *testing.T, p transport.Process) []string {
+	t.Helper()
+
+	ep, ok := p.(*transport.ExecProcess)
+	require.True(t, ok, "expected *ExecProcess, got %T", p)
+
+	return ep.Args
+}
+
+func TestOpenSSH(t *testing.T) {
+	ctx := context.Background()
+	ssh := &transport.OpenSSH{User: "root", Host: "example.com"}
+
+	assert.Equal(t, "ssh://root@example.com", ssh.Destination())
+	assert.Equal(t,
+		[]string{"ssh", "-T", "-oBatchMode=yes", "ssh://root@example.com", "uname", "-a"},
+		args(t, ssh.Command(ctx, "uname", "-a")))
+	script := args(t, ssh.Script(ctx, "zsh", false))
+	require.Len(t, script, 5)
+	assert.Equal(t, []string{"ssh", "-T", "-oBatchMode=yes", "ssh://root@example.com"}, script[:4])
+	assert.True(t, strings.HasPrefix(script[4], "sh -c "), "got %q", script[4])
+	assert.Contains(t, script[4], "zsh")
+	assert.Equal(t,
+		[]string{"ssh", "ssh://root@example.com"},
+		args(t, ssh.Interactive()))
+}
+
+func TestOpenSSHNoUser(t *testing.T) {
+	ssh := &transport.OpenSSH{Host: "example.com"}
+
+	assert.Equal(t, "ssh://example.com", ssh.Destination())
+}
+
+func TestOpenSSHIPv6Port(t *testing.T) {
+	ctx := context.Background()
+	ssh := &transport.OpenSSH{User: "root", Host: "2001:db8::1", Port: 2222}
+
+	assert.Equal(t, "ssh://root@[2001:db8::1]:2222", ssh.Destination())
+	assert.Equal(t,
+		[]string{"ssh", "-T", "-oBatchMode=yes", "ssh://root@[2001:db8::1]:2222", "true"},
+		args(t, ssh.Command(ctx, "true")))
+	assert.Equal(t, "-T -oBatchMode=yes -p 2222", ssh.NixSSHOpts())
+}
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	local := &transport.Local{}
+
+	assert.Equal(t, "local", local.Destination())
+	assert.Equal(t, []string{"sh", "-c", "uname -a"}, args(t, local.Command(ctx, "uname", "-a")))
+	assert.Equal(t, []string{"sh", "-c"}, args(t, local.Script(ctx, "", false))[:2])
+}
+
+func TestScriptShell(t *testing.T) {
+	ctx := context.Background()
+	local := &transport.Local{}
+	tcs := []struct {
+		shell, want, stderr string
+	}{
+		{shell: "sh", want: "sh"},
+		{shell: "", want: transport.DefaultScriptShell},
+		{shell: "labcoat-missing-shell", want: "sh", stderr: "labcoat-missing-shell not found"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.shell, func(t *testing.T) {
+			if _, err := exec.LookPath(tc.want); err != nil {
+				t.Skip(tc.want + " not available")
+			}
+
+			var stdout, stderr strings.Builder
+			proc := local.Script(ctx, tc.shell, false)
+			proc.SetStdin(strings.NewReader("echo \"$_lc_sh\"\n"))
+			proc.SetStdout(&stdout)
+			proc.SetStderr(&stderr)
+			require.NoError(t, proc.Run())
+
+			assert.Equal(t, tc.want+"\n", stdout.String())
+			assert.Contains(t, stderr.String(), tc.stderr)
+		})
+	}
+}
+
+func TestScriptSecret(t *testing.T) {
+	ctx := context.Background()
+	local := &transport.Local{}
+
+	// The secret must not reach the interpreter, which may read ahead of the running command.
+	for _, shell := range []string{"bash", "dash", "sh"} {
+		t.Run(shell, func(t *testing.T) {
+			if _, err := exec.LookPath(shell); err != nil {
+				t.Skip(shell + " not available")
+			}
+
+			var stdout strings.Builder
+			proc := local.Script(ctx, shell, true)
+			proc.SetStdin(strings.NewReader("s3cret\necho \"[$_lc_secret]\"\necho done\n"))
+			proc.SetStdout(&stdout)
+			require.NoError(t, proc.Run())
+
+			assert.Equal(t, "[s3cret]\ndone\n", stdout.String())
+		})
+	}
+}
+
+func TestShCmdline(t *testing.T) {
+	// Nested quoting places quotes and backslashes inside the outer quotes.
+	script := `printf '%s|%s\n' "it's" 'a\\b'; sh -c 'echo '\''$HOME'\'''`
+	want := "it's|a\\\\b\n$HOME\n"
+
+	// Login shells which must run the command line as sh would.
+	for _, shell := range []string{"sh", "bash", "dash", "fish"} {
+		t.Run(shell, func(t *testing.T) {
+			if _, err := exec.LookPath(shell); err != nil {
+				t.Skip(shell + " not available")
+			}
+
+			out, err := exec.Command(shell, "-c", transport.ShCmdline(script)).CombinedOutput()
+			require.NoError(t, err, string(out))
+			assert.Equal(t, want, string(out))
+		})
+	}
+}
+
+func TestMachinectl(t *testing.T) {
+	ctx := context.Background()
+	mc := &transport.Machinectl{Machine: "web"}
+
+	assert.Equal(t, "machine://web", mc.Destination())
+	assert.Equal(t,
+		[]string{
+			"systemd-run", "--machine=web", "--quiet", "--pipe", "--wait", "--collect",
+			"sh", "-c", "uname -a",
+		},
+		args(t, mc.Command(ctx, "uname", "-a")))
+	assert.Equal(t,
+		[]string{"machinectl", "shell", "web"},
+		args(t, mc.Interactive()))
+}
+
+func TestFakeContextCancelled(t *testing.T) {
+	called := false
+	fake := &transport.Fake{
+		Handler: func(context.Context, transport.FakeRequest) error {
+			called = true
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := fake.Command(ctx, "true").Run()
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, called)
+}
+
+func TestConnectionFailed(t *testing.T) {
+	ctx := context.Background()
+
+	// Fake ssh fails to connect.
+	bin := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "ssh"), []byte("#!/bin/sh\nexit 255\n"), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ssh := (&transport.OpenSSH{Host: "example.com"}).Command(ctx, "true").Run()
+	assert.True(t, transport.ConnectionFailed(ssh))
+	var coder interface{ ExitCode() int }
+	require.ErrorAs(t, ssh, &coder)
+	assert.Equal(t, 255, coder.ExitCode())
+
+	// Other transports exit 255 only when the command does.
+	local := (&transport.Local{}).Command(ctx, "exit 255").Run()
+	assert.Error(t, local)
+	assert.False(t, transport.ConnectionFailed(local))
+
+	assert.True(t, transport.ConnectionFailed(&transport.ConnectError{Err: errors.New("refused")}))
+	assert.False(t, transport.ConnectionFailed(transport.FakeExitError(255)))
+	assert.False(t, transport.ConnectionFailed(errors.New("exit status 255")))
+	assert.False(t, transport.ConnectionFailed(nil))
+}
+
+func TestOpenSSHOptions(t *testing.T) {
+	ctx := context.Background()
+	ssh := &transport.OpenSSH{Host: "example.com", Options: []string{"-oControlMaster=auto"}}
+
+	assert.Equal(t,
+		[]string{"ssh", "-T", "-oBatchMode=yes", "-oControlMaster=auto", "ssh://example.com", "true"},
+		args(t, ssh.Command(ctx, "true")))
+	assert.Equal(t,
+		[]string{"ssh", "-oControlMaster=auto", "ssh://example.com"},
+		args(t, ssh.Interactive()))
+	assert.Equal(t, "-T -oBatchMode=yes -oControlMaster=auto", ssh.NixSSHOpts())
+}
+
+func TestControlMaster(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	cm, err := transport.NewControlMaster()
+	require.NoError(t, err)
+
+	opts := cm.Options("ssh://example.com")
+	require.Len(t, opts, 3)
+	assert.Contains(t, opts, "-oControlMaster=auto")
+
+	path, ok := strings.CutPrefix(opts[1], "-oControlPath=")
+	require.True(t, ok)
+	dir := filepath.Dir(path)
+	assert.Equal(t, runtimeDir, filepath.Dir(dir))
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	require.NoError(t, cm.Close())
+	_, err = os.Stat(dir)
+	assert.ErrorIs(t, err, os.ErrNotExist, "Close should remove socket dir")
+}
+
+func TestControlMasterCloseOptions(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	// Fake ssh records the arguments of each exit request.
+	bin := t.TempDir()
+	log := filepath.Join(bin, "ssh.log")
+	script := "#!/bin/sh\necho \"$*\" >>" + log + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "ssh"), []byte(script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cm, err := transport.NewControlMaster()
+	require.NoError(t, err)
+
+	opts := cm.Options("ssh://root@example.com:2222", "-oProxyJump=jump")
+	assert.Equal(t, "-oProxyJump=jump", opts[len(opts)-1])
+
+	// Masters are only stopped if a socket exists.
+	path, _ := strings.CutPrefix(opts[1], "-oControlPath=")
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(path), "socket"), nil, 0o600))
+	require.NoError(t, cm.Close())
+
+	got, err := os.ReadFile(log)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join(opts, " ")+" -O exit ssh://root@example.com:2222\n", string(got))
+}
@@ -0,0 +1,160 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/labcoat/internal/config"
+)
+
+// actionItem represents an entry in the action menu.
+type actionItem struct {
+	action  config.Action
+	binding key.Binding
+}
+
+func (item actionItem) FilterValue() string { return item.action.Name }
+func (item actionItem) Title() string {
+	if item.binding.Enabled() {
+		return item.action.Name + " " + subtleStyle.Render("("+item.binding.Help().Key+")")
+	}
+	return item.action.Name
+}
+func (item actionItem) Description() string {
+	where := "remote"
+	if item.action.Local {
+		where = "local"
+	}
+	if item.action.Script != "" {
+		return where + " script"
+	}
+	return where + ": " + item.action.Command
+}
+
+// Requests that the action menu of host be opened, once target info is available.
+type actionMenuRequestMsg struct {
+	host *hostModel
+}
+
+// Requests that action be run on host, once target info is available.
+type hostActionRequestMsg struct {
+	host   *hostModel
+	action config.Action
+}
+
+// openActionMenu displays the actions available for host.
+func (m *Model) openActionMenu(host *hostModel) tea.Cmd {
+	queued := &queuedAction{
+		kind:  queueActionMenu,
+		label: "actions",
+		msg:   actionMenuRequestMsg{host: host},
+	}
+	if ok, cmd := m.requireTargetOrQueue(host, queued); !ok {
+		return cmd
+	}
+
+	var items []list.Item
+	for i, a := range m.config.Commands.Actions {
+		if a.Matches(host.target.Tags) {
+			items = append(items, actionItem{action: a, binding: m.keys.Actions[i]})
+		}
+	}
+	if len(items) == 0 {
+		return func() tea.Msg {
+			return errorFlashMsg{text: fmt.Sprintf("No actions configured for %q", host.name)}
+		}
+	}
+
+	m.openMenu("Actions for "+host.name, "Select action: enter to run, esc to close", items,
+		func(item list.Item) tea.Cmd {
+			return m.hostActionCmd(host, item.(actionItem).action)
+		})
+	return nil
+}
+
+// hostActionCmd runs action on host, after confirmation if required.
+func (m *Model) hostActionCmd(host *hostModel, action config.Action) tea.Cmd {
+	queued := &queuedAction{
+		kind:  actionRunCommand,
+		label: action.Name,
+		msg:   hostActionRequestMsg{host: host, action: action},
+	}
+	if ok, cmd := m.requireTargetOrQueue(host, queued); !ok {
+		return cmd
+	}
+	if !action.Matches(host.target.Tags) {
+		return func() tea.Msg {
+			return errorFlashMsg{
+				text: fmt.Sprintf("Action %q is not available for %q", action.Name, host.name),
+			}
+		}
+	}
+
+	msg := hostRunCommandMsg{
+		host:    host,
+		action:  actionRunCommand,
+		local:   action.Local,
+		session: action.Name,
+	}
+	switch {
+	case action.Local:
+		// Scripts and commands are both interpreted by the local sh.
+		cmdline := action.Command + action.Script
+		msg.prog, msg.args = "sh", []string{"-c", cmdline}
+		msg.label = action.Name + ": " + firstLine(cmdline)
+	case action.Script != "":
+		msg.script = action.Script
+		msg.label = action.Name + " (script)"
+	default:
+		msg.prog = action.Command
+	}
+	run := func() tea.Msg { return msg }
+
+	if !action.Confirm {
+		return run
+	}
+
+	dest := host.target.DeployHost
+	if action.Local {
+		dest = host.name
+	}
+	return func() tea.Msg {
+		return confirmationMsg{
+			text:   fmt.Sprintf("Run %q for %q? y/n:", action.Name, dest),
+			yesCmd: run,
+		}
+	}
+}
+
+// actionEnv returns the environment describing host to local actions.
+func (m *Model) actionEnv(host *hostModel) [][2]string {
+	return [][2]string{
+		{"LABCOAT_HOST", host.name},
+		{"LABCOAT_DEPLOY_HOST", host.target.DeployHost},
+		{"LABCOAT_DEPLOY_USER", host.target.DeployUser},
+		{"LABCOAT_DESTINATION", host.target.Destination.String()},
+		{"LABCOAT_FLAKE", m.flakePath},
+	}
+}
+
+// matchAction returns the index of the action bound to msg, or -1.
+func matchAction(msg tea.KeyMsg, bindings []key.Binding) int {
+	for i, b := range bindings {
+		if key.Matches(msg, b) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func firstLine(s string) string {
+	line, _, more := strings.Cut(strings.TrimSpace(s), "\n")
+	if more {
+		return line + " …"
+	}
+	return line
+}
@@ -2,16 +2,22 @@ package ui
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"os"
+	"slices"
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/jhillyerd/labcoat/internal/runner"
+	"github.com/jhillyerd/labcoat/internal/transport"
 )
 
 type hostDeployMsg struct {
-	host *hostModel
+	host        *hostModel
+	preflighted bool // Preflight checks passed.
+	input       bool // Keep stdin open, attaching input once started.
 }
 
 // Sent when the runner has new output/status to display.
@@ -30,15 +36,16 @@ func (m *Model) hostDeployCmd(host *hostModel) tea.Cmd {
 
 func (m *Model) handleHostDeployMsg(msg hostDeployMsg) tea.Cmd {
 	host := msg.host
-	if ok, cmd := requireHostTarget("hostDeployMsg", host); !ok {
+	queued := &queuedAction{kind: actionDeploy, label: "deploy", msg: msg}
+	if ok, cmd := m.requireTargetOrQueue(host, queued); !ok {
 		return cmd
 	}
 
 	m.setVisibleHostTab(hostTabDeploy)
 
-	if host.deploy.runner != nil && host.deploy.runner.Running() {
+	if !host.readyFor(actionDeploy) {
 		slog.Info("host deploy already running", "host", host.name)
-		return nil
+		return m.enqueue(host, queued, "the current deploy finishes")
 	}
 
 	onUpdate := func(r *runner.Model) tea.Msg {
@@ -49,35 +56,100 @@ func (m *Model) handleHostDeployMsg(msg hostDeployMsg) tea.Cmd {
 	args := []string{
 		"--flake",
 		".#" + host.name,
-		"--target-host",
-		host.target.DeployUser + "@" + host.target.DeployHost,
+	}
+	switch host.target.Transport {
+	case "ssh", "ssh-native":
+		args = append(args, "--target-host", host.target.Destination.TargetHost())
+	case "local":
+		// Deploy to the machine running labcoat.
+	default:
+		return func() tea.Msg {
+			return errorFlashMsg{
+				text: fmt.Sprintf("Deploy is not supported by transport %q", host.target.Transport),
+			}
+		}
 	}
 	if m.config.Nix.DefaultBuildHost != "" {
 		args = append(args, "--build-host", m.config.Nix.DefaultBuildHost)
 	}
+	sudo := host.target.Transport != "local" &&
+		m.config.UseSudo(actionDeploy, host.target.DeployUser)
+	if sudo {
+		if host.sudo == nil {
+			return m.requireSudo(host, msg)
+		}
+		args = append(args, "--use-remote-sudo")
+		if host.sudo.Password != "" {
+			// Password is read from stdin.
+			args = append(args, "--ask-sudo-password")
+		}
+	}
 	args = append(args, "switch")
 
+	if host.target.Transport != "local" && m.config.Hosts.DeployPreflight && !msg.preflighted {
+		return m.hostPreflightCmd(host, msg)
+	}
+
 	ctx, cancel := context.WithCancel(m.ctx)
-	srunner := runner.NewLocal(ctx, onUpdate, m.flakePath, "nixos-rebuild", args...)
-	srunner.PassEnv("PATH")
+	srunner := m.newLocalRunner(ctx, onUpdate, "nixos-rebuild", args...)
+	srunner.SetTimeout(m.config.Timeouts.Deploy.Std())
 
 	// Attempt to fix systemd-run hang, but it appears it's a nixos bug, may be fixed in 24.xx:
 	// https://github.com/NixOS/nixpkgs/issues/262686
 	// https://github.com/NixOS/nixpkgs/pull/263360 (merged)
-	srunner.SetEnv("NIX_SSHOPTS", "-T -oBatchMode=yes")
-
+	sshOpts := "-T -oBatchMode=yes"
+	if t, ok := host.transport.(*transport.OpenSSH); ok {
+		// Share ssh options, including any ControlMaster, pinned host keys and jump host.
+		sshOpts = t.NixSSHOpts()
+	} else {
+		opts := append(m.sshOptions(host.target), host.target.Destination.SSHOptions()...)
+		sshOpts = strings.Join(append([]string{sshOpts}, opts...), " ")
+	}
+	srunner.SetEnv("NIX_SSHOPTS", sshOpts)
+
+	var attach bool
+	switch {
+	case msg.input:
+		if err := srunner.EnableInput(); err != nil {
+			slog.Error("Failed to enable runner input", "host", host.name, "err", err)
+			break
+		}
+		attach = true
+		if sudo {
+			if err := srunner.WriteInput(host.sudo.Input()); err != nil {
+				slog.Error("Failed to write sudo password", "host", host.name, "err", err)
+			}
+		}
+	case sudo:
+		srunner.SetInput(host.sudo.Input())
+	}
 	srunner.Styles.StatusSuffix = subtleStyle
 	host.deploy.runner = srunner
+	host.deploy.notice = notice{}
 	host.deploy.cancel = cancel
+	dest := host.target.Destination.URL()
+	if host.target.Transport == "local" {
+		dest = srunner.Destination()
+	}
+	var revCmd tea.Cmd
+	host.deploy.history, revCmd = m.newHistoryRun(actionDeploy, dest)
 
 	// Init status display.
 	intro := lipgloss.NewStyle().
 		Foreground(subtleColor).
-		Render(srunner.String()) + "\n"
+		Render(srunner.String()+"\n"+renderEnv(srunner.Env(), m.config.Env.Pass)) + "\n"
+	if msg.preflighted {
+		intro = host.deploy.preflight + "\n" + intro
+	}
 	host.deploy.intro = intro
 	host.deploy.contentPanel.SetContent(intro)
 
-	return srunner.Init()
+	cmd := tea.Batch(srunner.Init(), revCmd)
+	if attach {
+		cmd = tea.Batch(cmd, m.attachStartedInput(srunner))
+	}
+
+	return cmd
 }
 
 func (m *Model) handleHostDeployOutputMsg(msg hostDeployOutputMsg) tea.Cmd {
@@ -90,13 +162,18 @@ func (m *Model) handleHostDeployOutputMsg(msg hostDeployOutputMsg) tea.Cmd {
 	srunner := host.deploy.runner
 	_, cmd := srunner.Update(nil)
 
+	if msg.final {
+		m.releaseRunnerInput(srunner)
+		cmd = tea.Batch(cmd, m.runnerFailureCmd(host, srunner),
+			m.recordHistoryCmd(host, srunner, &host.deploy.history),
+			m.notifyCmd(host, actionDeploy, srunner, &host.deploy.notice), m.runQueueCmd(host))
+	}
+
 	// Render and cache output content.
 	panel := &host.deploy.contentPanel
 	follow := panel.AtBottom()
 	output := host.deploy.intro
-	output += runner.FormatOutput(
-		srunner.View(),
-		func(s string) string { return labelStyle.Render(s) })
+	output += srunner.View()
 
 	// Carriage returns cause formatting issues.
 	output = strings.ReplaceAll(output, "\r", "")
@@ -113,3 +190,20 @@ func (m *Model) handleHostDeployOutputMsg(msg hostDeployOutputMsg) tea.Cmd {
 
 	return cmd
 }
+
+// renderEnv describes the environment of a local runner.  Values of variables passed through from
+// the parent environment are hidden, as they may contain secrets.
+func renderEnv(env []string, passed []string) string {
+	var inherited, set []string
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		if slices.Contains(passed, name) && value == os.Getenv(name) {
+			inherited = append(inherited, name)
+		} else {
+			set = append(set, fmt.Sprintf("%s=%q", name, value))
+		}
+	}
+
+	return "env inherited: " + strings.Join(inherited, " ") + "\n" +
+		"env set: " + strings.Join(set, " ")
+}
@@ -0,0 +1,234 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jhillyerd/labcoat/internal/health"
+)
+
+// Hosts due a health check are found at this interval, or the check interval if shorter.
+const healthTick = 5 * time.Second
+
+// Enable/disable xterm focus reporting, the terminal then sends CSI I and CSI O.
+const (
+	focusReportingOn  = "\x1b[?1004h"
+	focusReportingOff = "\x1b[?1004l"
+)
+
+// Sent every healthTick while health polling is enabled.
+type healthTickMsg struct{}
+
+// Sent when a health check of host has completed.
+type hostHealthMsg struct {
+	host   *hostModel
+	result health.Result
+}
+
+// healthEnabled is true if hosts are polled in the background.
+func (m *Model) healthEnabled() bool {
+	return m.config.Health.Interval > 0
+}
+
+// startHealthCmd enables focus reporting, and starts polling hosts.
+func (m *Model) startHealthCmd() tea.Cmd {
+	terminal := m.terminal
+	return tea.Batch(
+		func() tea.Msg {
+			setFocusReporting(terminal, true)
+			return nil
+		},
+		m.healthTickCmd())
+}
+
+// stopHealth disables focus reporting.
+func (m *Model) stopHealth() {
+	if m.healthEnabled() {
+		setFocusReporting(m.terminal, false)
+	}
+}
+
+// pauseFocusReportingCmd disables focus reporting before a process takes over the terminal, so
+// focus events are not sent to it as input.  See resumeFocusReporting.
+func (m *Model) pauseFocusReportingCmd() tea.Cmd {
+	if !m.healthEnabled() {
+		return nil
+	}
+
+	terminal := m.terminal
+	return func() tea.Msg {
+		setFocusReporting(terminal, false)
+		return nil
+	}
+}
+
+// resumeFocusReporting enables focus reporting, ie once a process has released the terminal.
+func (m *Model) resumeFocusReporting() {
+	if m.healthEnabled() {
+		setFocusReporting(m.terminal, true)
+	}
+}
+
+func setFocusReporting(terminal io.Writer, on bool) {
+	seq := focusReportingOff
+	if on {
+		seq = focusReportingOn
+	}
+	if _, err := io.WriteString(terminal, seq); err != nil {
+		slog.Warn("Failed to set focus reporting", "on", on, "err", err)
+	}
+}
+
+func (m *Model) healthTickCmd() tea.Cmd {
+	return tea.Tick(min(healthTick, m.config.Health.Interval.Std()), func(time.Time) tea.Msg {
+		return healthTickMsg{}
+	})
+}
+
+// healthPaused is true if polling should pause, as the user is not looking.
+func (m *Model) healthPaused() bool {
+	if !m.focused {
+		return true
+	}
+	idle := m.config.Health.IdleAfter.Std()
+
+	return idle > 0 && time.Since(m.lastActivity) > idle
+}
+
+// handleHealthTickMsg checks the hosts that are due, first fetching target info for hosts the user
+// has not yet selected.
+func (m *Model) handleHealthTickMsg(_ healthTickMsg) tea.Cmd {
+	cmds := []tea.Cmd{m.healthTickCmd()}
+	if m.healthPaused() {
+		return cmds[0]
+	}
+
+	// Leave nix workers free for the user, fetching no more target info than hosts checked at once.
+	fetching := 0
+	for _, host := range m.hosts {
+		if host.target == nil && host.health.checking {
+			fetching++
+		}
+	}
+
+	interval := m.config.Health.Interval.Std()
+	for _, host := range m.hosts {
+		if host.health.checking || host.reboot.tracking {
+			continue
+		}
+		if time.Since(host.health.result.Checked) < interval {
+			continue
+		}
+		if host.target == nil {
+			if host.targetPending || fetching >= m.config.Health.Concurrency {
+				continue
+			}
+			fetching++
+			host.health.checking = true
+			cmds = append(cmds, m.fetchTargetInfoCmd(host, m.ctx, nil, true))
+			continue
+		}
+		cmds = append(cmds, m.hostHealthCmd(host))
+	}
+
+	return tea.Batch(cmds...)
+}
+
+// handleHealthTargetInfoErr records the failure to fetch target info for a health check, which is
+// retried once the check is next due.
+func (m *Model) handleHealthTargetInfoErr(host *hostModel, err error) tea.Cmd {
+	slog.Warn("Failed to fetch target info for health check", "host", host.name, "err", err)
+	host.health.result = health.Result{Err: err, Checked: time.Now()}
+
+	return nil
+}
+
+// hostHealthCmd checks the health of host, limited by the health pool.
+func (m *Model) hostHealthCmd(host *hostModel) tea.Cmd {
+	host.health.checking = true
+
+	// Reachability can only be checked for hosts on the network we can route to directly.
+	target := host.target
+	reach := target.DeployHost
+	if target.JumpHost != "" || (target.Transport != "ssh" && target.Transport != "ssh-native") {
+		reach = ""
+	}
+
+	ctx, checker, pool, t := m.ctx, m.healthChecker, m.healthPool, host.transport
+	port := target.Destination.Port
+	return func() tea.Msg {
+		worker, err := pool.Get(ctx)
+		if err != nil {
+			return hostHealthMsg{host: host, result: health.Result{Err: err, Checked: time.Now()}}
+		}
+		defer worker.Done()
+
+		return hostHealthMsg{host: host, result: checker.Check(ctx, t, reach, port)}
+	}
+}
+
+func (m *Model) handleHostHealthMsg(msg hostHealthMsg) tea.Cmd {
+	host, result := msg.host, msg.result
+	host.health.checking = false
+
+	if prev := host.health.result.State; prev != result.State {
+		slog.Info("Host health changed", "host", host.name, "from", prev, "to", result.State,
+			"err", result.Err)
+	}
+	host.health.result = result
+
+	return nil
+}
+
+// handleFocusMsg pauses health polling while the terminal is unfocused.
+func (m *Model) handleFocusMsg(focused bool) tea.Cmd {
+	slog.Debug("Terminal focus changed", "focused", focused)
+	m.focused = focused
+	if focused {
+		m.lastActivity = time.Now()
+	}
+
+	return nil
+}
+
+// focusEvent returns whether msg reports the terminal gaining or losing focus.  Bubble Tea v0.26
+// does not parse focus events, delivering them as unknown CSI sequences.
+func focusEvent(msg tea.Msg) (focused bool, ok bool) {
+	s, isStringer := msg.(fmt.Stringer)
+	if !isStringer {
+		return false, false
+	}
+	if _, isKey := msg.(tea.KeyMsg); isKey {
+		return false, false
+	}
+
+	switch s.String() {
+	case "?CSI[73]?": // CSI I
+		return true, true
+	case "?CSI[79]?": // CSI O
+		return false, true
+	}
+
+	return false, false
+}
+
+var healthStyles = map[health.State]lipgloss.Style{
+	health.StateUnknown:     subtleStyle,
+	health.StateReachable:   lipgloss.NewStyle().Foreground(successColor),
+	health.StateUnreachable: lipgloss.NewStyle().Foreground(failedColor),
+	health.StateSSHFailing:  lipgloss.NewStyle().Foreground(errorColor),
+}
+
+// healthDot returns the host list status dot for host.
+func healthDot(host *hostModel) string {
+	state := host.health.result.State
+	if state == health.StateUnknown {
+		return healthStyles[state].Render("○")
+	}
+
+	return healthStyles[state].Render("●")
+}
@@ -0,0 +1,281 @@
+package ui
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jhillyerd/labcoat/internal/history"
+	"github.com/jhillyerd/labcoat/internal/runner"
+)
+
+// Sent once a completed run has been written to history.
+type historyRecordedMsg struct {
+	host   *hostModel
+	record history.Record
+	err    error
+}
+
+// historyRun describes a runner to be recorded in history once it completes.
+type historyRun struct {
+	kind     string // Action, ie deploy.
+	dest     string
+	flakeRev *flakeRev // Nil when history is disabled.
+	recorded bool      // Complete runners may report their final state more than once.
+}
+
+// flakeRev is the revision of the flake when a run started, resolved in the background as git may
+// be slow on large repositories.
+type flakeRev struct {
+	done chan struct{} // Closed once rev is resolved.
+	rev  string
+}
+
+// historyItem represents a run in the history menu.
+type historyItem struct {
+	record history.Record
+}
+
+func (item historyItem) FilterValue() string { return item.record.Kind + " " + item.record.Command }
+func (item historyItem) Title() string {
+	return historyResultMark(item.record) + " " +
+		item.record.Start.Local().Format(time.DateTime) + " " + item.record.Kind
+}
+func (item historyItem) Description() string { return item.record.Command }
+
+// historyListItem returns from a displayed run to the list of runs.
+type historyListItem struct{}
+
+func (historyListItem) FilterValue() string { return "" }
+func (historyListItem) Title() string       { return "All runs" }
+func (historyListItem) Description() string { return "List recorded runs" }
+
+// newHistoryRun describes a run of kind against dest that is about to start.  The returned command
+// resolves the current revision of the flake, and must be run alongside the runner.
+func (m *Model) newHistoryRun(kind string, dest string) (historyRun, tea.Cmd) {
+	run := historyRun{kind: kind, dest: dest}
+	if m.history == nil {
+		return run, nil
+	}
+
+	rev := &flakeRev{done: make(chan struct{})}
+	run.flakeRev = rev
+	ctx, dir := m.ctx, m.flakePath
+	return run, func() tea.Msg {
+		defer close(rev.done)
+
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		rev.rev = history.GitRevision(ctx, dir)
+
+		return nil
+	}
+}
+
+// recordHistoryCmd writes the completed runner r to history, once.
+func (m *Model) recordHistoryCmd(host *hostModel, r *runner.Model, run *historyRun) tea.Cmd {
+	if m.history == nil || run.recorded {
+		return nil
+	}
+	run.recorded = true
+
+	rec := history.Record{
+		Host:        host.name,
+		Kind:        run.kind,
+		Command:     r.String(),
+		Destination: run.dest,
+		Start:       r.Started(),
+		End:         r.Finished(),
+		State:       r.StateString(),
+		Successful:  r.Successful(),
+		ExitCode:    r.ExitCode(),
+	}
+	var output bytes.Buffer
+	if _, err := r.CopyTo(&output); err != nil {
+		slog.Error("Failed to copy runner output", "host", host.name, "err", err)
+	}
+
+	store, rev := m.history, run.flakeRev
+	return func() tea.Msg {
+		if rev != nil {
+			<-rev.done
+			rec.FlakeRev = rev.rev
+		}
+		rec, err := store.Add(rec, output.Bytes())
+		return historyRecordedMsg{host: host, record: rec, err: err}
+	}
+}
+
+func (m *Model) handleHistoryRecordedMsg(msg historyRecordedMsg) tea.Cmd {
+	host := msg.host
+	if msg.err != nil {
+		slog.Error("Failed to record history", "host", host.name, "err", msg.err)
+		return func() tea.Msg { return errorFlashMsg{text: "History: " + msg.err.Error()} }
+	}
+
+	if host.history.loaded {
+		records := append([]history.Record{msg.record}, host.history.records...)
+		if limit := m.config.General.HistoryLimit; limit > 0 && len(records) > limit {
+			records = records[:limit]
+		}
+		host.history.records = records
+		m.renderHistoryContent(host)
+	}
+
+	return nil
+}
+
+// loadHistory reads the recorded runs for host, once.
+func (m *Model) loadHistory(host *hostModel) {
+	if host.history.loaded {
+		return
+	}
+
+	if m.history != nil {
+		records, err := m.history.List(host.name)
+		if err != nil {
+			slog.Error("Failed to load history", "host", host.name, "err", err)
+			host.history.err = err
+		}
+		host.history.records = records
+	}
+	host.history.loaded = true
+	m.renderHistoryContent(host)
+}
+
+// openHistoryMenu lets the user pick a recorded run of host to display.
+func (m *Model) openHistoryMenu(host *hostModel) tea.Cmd {
+	if host == nil {
+		slog.Error("openHistoryMenu called with nil host (bug)")
+		return nil
+	}
+	if m.history == nil {
+		return func() tea.Msg { return errorFlashMsg{text: "History is disabled"} }
+	}
+
+	m.setVisibleHostTab(hostTabHistory)
+	m.loadHistory(host)
+	if len(host.history.records) == 0 {
+		return func() tea.Msg {
+			return errorFlashMsg{text: fmt.Sprintf("No history recorded for %q", host.name)}
+		}
+	}
+
+	items := make([]list.Item, 0, len(host.history.records)+1)
+	if host.history.selected != nil {
+		items = append(items, historyListItem{})
+	}
+	for _, rec := range host.history.records {
+		items = append(items, historyItem{record: rec})
+	}
+
+	m.openMenu("History of "+host.name, "Select run: enter to display, esc to close", items,
+		func(item list.Item) tea.Cmd {
+			host.history.selected = nil
+			host.history.output = ""
+			if item, ok := item.(historyItem); ok {
+				output, err := m.history.Output(item.record)
+				if err != nil {
+					slog.Error("Failed to read history output", "host", host.name, "err", err)
+					return func() tea.Msg { return errorFlashMsg{text: "History: " + err.Error()} }
+				}
+				host.history.selected = &item.record
+				host.history.output = string(output)
+			}
+			m.renderHistoryContent(host)
+			host.history.contentPanel.GotoTop()
+
+			return nil
+		})
+
+	return nil
+}
+
+// renderHistoryContent renders and caches the History tab content.
+func (m *Model) renderHistoryContent(host *hostModel) {
+	var content string
+	switch {
+	case m.history == nil:
+		content = subtleStyle.Render("History is disabled, see general.history in the config")
+	case host.history.selected != nil:
+		content = renderHistoryRecord(*host.history.selected) + "\n" + host.history.output
+	default:
+		content = m.renderHistoryList(host)
+	}
+
+	// Carriage returns cause formatting issues.
+	content = strings.ReplaceAll(content, "\r", "")
+
+	// Truncate content width to preserve correct viewport line counts & scrolling.
+	// Viewport bug: https://github.com/charmbracelet/bubbles/issues/479
+	content = lipgloss.NewStyle().MaxWidth(m.sizes.contentPanel.width).Render(content)
+
+	host.history.contentPanel.SetContent(content)
+}
+
+func (m *Model) renderHistoryList(host *hostModel) string {
+	if host.history.err != nil {
+		return lipgloss.NewStyle().Foreground(errorColor).Render(host.history.err.Error())
+	}
+	if len(host.history.records) == 0 {
+		return subtleStyle.Render("No history recorded for " + host.name)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(subtleStyle.Render(fmt.Sprintf(
+		"%d recorded runs, press %s to display one", len(host.history.records),
+		m.keys.OpenHistory.Help().Key)) + "\n\n")
+	for _, rec := range host.history.records {
+		fmt.Fprintf(&sb, "%s %s %s %-10s %s\n",
+			historyResultMark(rec),
+			rec.Start.Local().Format(time.DateTime),
+			subtleStyle.Render(fmt.Sprintf("%8s", rec.Duration().Round(time.Second))),
+			rec.Kind,
+			rec.Command)
+	}
+
+	return sb.String()
+}
+
+// renderHistoryRecord renders the details of a recorded run.
+func renderHistoryRecord(rec history.Record) string {
+	exit := "none"
+	if rec.ExitCode >= 0 {
+		exit = strconv.Itoa(rec.ExitCode)
+	}
+	rev := rec.FlakeRev
+	if rev == "" {
+		rev = "unknown"
+	}
+
+	fields := [][2]string{
+		{"Command", rec.Command},
+		{"Destination", rec.Destination},
+		{"Started", rec.Start.Local().Format(time.DateTime)},
+		{"Duration", rec.Duration().Round(time.Millisecond).String()},
+		{"Result", historyResultMark(rec) + " " + rec.State + ", exit code " + exit},
+		{"Flake rev", rev},
+	}
+
+	var sb strings.Builder
+	for _, f := range fields {
+		sb.WriteString(subtleStyle.Render(fmt.Sprintf("%-12s", f[0]+":")) + " " + f[1] + "\n")
+	}
+
+	return sb.String()
+}
+
+func historyResultMark(rec history.Record) string {
+	if rec.Successful {
+		return lipgloss.NewStyle().Foreground(successColor).Render("✓")
+	}
+
+	return lipgloss.NewStyle().Foreground(failedColor).Render("✗")
+}
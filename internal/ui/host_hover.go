@@ -0,0 +1,116 @@
+package ui
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/labcoat/internal/runner"
+)
+
+// Actions taken when the user hovers over a host, see config.General.HoverAction.
+const (
+	hoverActionNone   = "none"
+	hoverActionTarget = "target"
+	hoverActionStatus = "status"
+)
+
+// hover is work triggered by hovering over a host, cancelled when the selection moves away.
+type hover struct {
+	host   *hostModel
+	ctx    context.Context // Done once the selection has moved away.
+	cancel context.CancelFunc
+	status *runner.Model // Status collection started by hovering, if any.
+}
+
+func (m *Model) handleHostChangedMsg(msg hostChangedMsg) tea.Cmd {
+	// slog.Debug("hostChanged", "host", msg.hostName)
+
+	m.selectedHost = m.hosts[msg.hostName]
+	m.updateContentPanel()
+
+	// Discard work for previous host.
+	m.stopHover()
+	if m.config.General.HoverAction == hoverActionNone {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	hv := &hover{host: m.selectedHost, ctx: ctx, cancel: cancel}
+	m.hover = hv
+
+	// Trigger hover action after delay.
+	delay := m.config.General.HoverDelay.Std()
+	return func() tea.Msg {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			return hostHoverMsg{hostName: msg.hostName, hover: hv}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (m *Model) handleHostHoverMsg(msg hostHoverMsg) tea.Cmd {
+	hv := msg.hover
+	if hv != m.hover {
+		// Selection has moved away.
+		return nil
+	}
+	host := hv.host
+
+	if host.target == nil {
+		if host.targetPending {
+			return nil
+		}
+		// Must collect target info before querying host status.
+		return m.hostTargetInfoCmd(host, hv)
+	}
+
+	if host.status.collected || m.config.General.HoverAction != hoverActionStatus {
+		// Only collect status on hover once.
+		return nil
+	}
+
+	return m.hoverStatusCmd(hv, host)
+}
+
+// hoverStatusCmd collects the status of host, cancelling it if the selection moves away from hv.
+func (m *Model) hoverStatusCmd(hv *hover, host *hostModel) tea.Cmd {
+	prev := host.status.runner
+	cmd := m.hostStatusCmd(host)
+	if r := host.status.runner; r != prev {
+		hv.status = r
+	}
+
+	return cmd
+}
+
+// hoverCollectsStatus is true if target info requested by hv should be followed by collecting
+// status; always true for requests not triggered by hovering.
+func (m *Model) hoverCollectsStatus(hv *hover) bool {
+	if hv == nil {
+		return true
+	}
+
+	return hv.ctx.Err() == nil && m.config.General.HoverAction == hoverActionStatus
+}
+
+// stopHover cancels unfinished work triggered by hovering.
+func (m *Model) stopHover() {
+	hv := m.hover
+	if hv == nil {
+		return
+	}
+	m.hover = nil
+
+	hv.cancel()
+	if hv.status != nil && hv.status.Running() {
+		slog.Debug("Cancelling hover status", "host", hv.host.name)
+		hv.status.Cancel()
+	}
+}
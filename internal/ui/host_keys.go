@@ -0,0 +1,118 @@
+package ui
+
+import (
+	"fmt"
+	"log/slog"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jhillyerd/labcoat/internal/nix"
+	"github.com/jhillyerd/labcoat/internal/transport"
+)
+
+// pinHostKeys adds the host keys declared in the flake to the private known_hosts file.  If the
+// user's known_hosts contains a stale entry for the host, prompt offers to update it; otherwise it
+// is reported in the host status, and offered once the user collects status.
+func (m *Model) pinHostKeys(host *hostModel, target *nix.TargetInfo, prompt bool) tea.Cmd {
+	if m.knownHosts == nil || len(target.HostKeys) == 0 {
+		return nil
+	}
+
+	name, port := target.DeployHost, target.Destination.Port
+	if err := m.knownHosts.Pin(name, target.HostKeys); err != nil {
+		slog.Error("Failed to pin host keys", "host", name, "err", err)
+		return func() tea.Msg { return errorFlashMsg{text: "Pin: " + err.Error()} }
+	}
+
+	mismatch, err := m.knownHosts.UserMismatch(name, port)
+	if err != nil {
+		slog.Warn("Failed to compare user known_hosts", "host", name, "err", err)
+		return nil
+	}
+	if !mismatch {
+		return nil
+	}
+
+	slog.Warn("User known_hosts does not match flake host keys", "host", name)
+	if !prompt {
+		// Confirmation would take the next y/n key of a user working on another host.
+		host.status.staleKeys = true
+		if host.status.runner == nil {
+			host.status.contentPanel.SetContent(renderStaleKnownHosts(name))
+		}
+		return nil
+	}
+
+	return replaceKnownHostsCmd(m.knownHosts, name, port)
+}
+
+// offerStaleKnownHostsCmd offers to replace the stale known_hosts entry of host reported by
+// pinHostKeys, once.
+func (m *Model) offerStaleKnownHostsCmd(host *hostModel) tea.Cmd {
+	if !host.status.staleKeys {
+		return nil
+	}
+	host.status.staleKeys = false
+
+	return replaceKnownHostsCmd(m.knownHosts, host.target.DeployHost, host.target.Destination.Port)
+}
+
+// replaceKnownHostsCmd asks the user to replace their known_hosts entry for host with the keys
+// declared in the flake.
+func replaceKnownHostsCmd(kh *transport.KnownHosts, host string, port int) tea.Cmd {
+	lines := kh.Lines(host, port)
+	return func() tea.Msg {
+		return confirmationMsg{
+			text: fmt.Sprintf(
+				"Your known_hosts entry for %q does not match the flake. Replace it? y/n:", host),
+			yesCmd: updateKnownHostsCmd(host, port, lines),
+		}
+	}
+}
+
+// renderStaleKnownHosts describes a stale known_hosts entry for the host status.
+func renderStaleKnownHosts(host string) string {
+	return lipgloss.NewStyle().Foreground(errorColor).Render(fmt.Sprintf(
+		"Your known_hosts entry for %q does not match the flake, get status to replace it", host)) +
+		"\n"
+}
+
+// hostKeyFailureCmd reports a host key verification failure, offering to remove a stale
+// known_hosts entry when the host's keys are not pinned.
+func (m *Model) hostKeyFailureCmd(host *hostModel) tea.Cmd {
+	name, port := host.target.DeployHost, host.target.Destination.Port
+	if m.knownHosts != nil && m.knownHosts.Pinned(name) {
+		return func() tea.Msg {
+			return errorFlashMsg{
+				text: fmt.Sprintf("Host key of %q does not match the keys declared in the flake", name),
+			}
+		}
+	}
+
+	return func() tea.Msg {
+		return confirmationMsg{
+			text: fmt.Sprintf(
+				"Host key of %q is unknown or changed. Remove it from known_hosts? y/n:", name),
+			yesCmd: updateKnownHostsCmd(name, port, nil),
+		}
+	}
+}
+
+// updateKnownHostsCmd replaces the entries for host on port in the user's known_hosts with lines.
+func updateKnownHostsCmd(host string, port int, lines []string) tea.Cmd {
+	return func() tea.Msg {
+		if err := transport.UpdateUserKnownHosts(host, port, lines); err != nil {
+			slog.Error("Failed to update known_hosts", "host", host, "err", err)
+			return errorFlashMsg{text: "known_hosts: " + err.Error()}
+		}
+
+		slog.Info("Updated known_hosts", "host", host, "keys", len(lines))
+		if len(lines) == 0 {
+			return errorFlashMsg{
+				text: fmt.Sprintf("Removed %q from known_hosts, SSH in to accept its new key", host),
+			}
+		}
+
+		return errorFlashMsg{text: fmt.Sprintf("Updated known_hosts for %q from the flake", host)}
+	}
+}
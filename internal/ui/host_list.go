@@ -12,19 +12,21 @@ import (
 
 // hostListModel is the list of hosts to manage.
 type hostListModel struct {
-	list     list.Model
-	prevItem list.Item // Used to detect when selected host changes for hover.
+	list      list.Model
+	prevItem  list.Item                // Used to detect when selected host changes for hover.
+	dot       func(host string) string // Status dot rendered before the host name, nil to omit.
+	indicator func(host string) string // Rendered after the host name, may be empty.
 }
 
 type jumpToLetterMsg string
 
-func newHostList(hosts []string) hostListModel {
+func newHostList(hosts []string, dot func(host string) string, indicator func(host string) string) hostListModel {
 	items := make([]list.Item, 0, len(hosts))
 	for _, host := range hosts {
 		items = append(items, hostItem(host))
 	}
 
-	hl := list.New(items, newItemDelegate(10), 10, 10)
+	hl := list.New(items, newItemDelegate(10, dot, indicator), 10, 10)
 	hl.Title = "Hosts"
 	hl.DisableQuitKeybindings()
 	hl.SetShowHelp(false)
@@ -32,7 +34,7 @@ func newHostList(hosts []string) hostListModel {
 	hl.Styles.TitleBar.Padding(0)
 	hl.Styles.StatusBar.Padding(0, 0, 1, 0)
 
-	return hostListModel{list: hl}
+	return hostListModel{list: hl, dot: dot, indicator: indicator}
 }
 
 // Init implements tea.Model.
@@ -88,6 +90,17 @@ func (m *hostListModel) handleHostChange() tea.Cmd {
 	return nil
 }
 
+// Select makes the named host the selected item, clearing any filter that hides it.
+func (m *hostListModel) Select(name string) {
+	m.list.ResetFilter()
+	for i, item := range m.list.Items() {
+		if string(item.(hostItem)) == name {
+			m.list.Select(i)
+			return
+		}
+	}
+}
+
 // View implements tea.Model.
 func (m hostListModel) View() string {
 	return m.list.View()
@@ -96,7 +109,7 @@ func (m hostListModel) View() string {
 // SetSize controls the size of list rendering.
 func (m *hostListModel) SetSize(width, height int) {
 	m.list.SetSize(width, height)
-	m.list.SetDelegate(newItemDelegate(width))
+	m.list.SetDelegate(newItemDelegate(width, m.dot, m.indicator))
 	m.list.Styles.StatusBar.Width(width)
 }
 
@@ -115,9 +128,13 @@ type itemDelegate struct {
 	itemStyle         lipgloss.Style
 	selectedItemStyle lipgloss.Style
 	maxWidth          int
+	dot               func(host string) string
+	indicator         func(host string) string
 }
 
-func newItemDelegate(maxWidth int) itemDelegate {
+func newItemDelegate(
+	maxWidth int, dot func(host string) string, indicator func(host string) string,
+) itemDelegate {
 	itemStyle := lipgloss.NewStyle().PaddingLeft(1)
 	selectedItemStyle := itemStyle.PaddingLeft(0).Foreground(lipgloss.Color("170"))
 
@@ -125,6 +142,8 @@ func newItemDelegate(maxWidth int) itemDelegate {
 		itemStyle:         itemStyle,
 		selectedItemStyle: selectedItemStyle,
 		maxWidth:          maxWidth,
+		dot:               dot,
+		indicator:         indicator,
 	}
 }
 
@@ -139,12 +158,25 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 		return
 	}
 
-	fn := d.itemStyle.MaxWidth(d.maxWidth).Render
+	fn := d.itemStyle.Render
 	if index == m.Index() {
 		fn = func(s ...string) string {
-			return d.selectedItemStyle.MaxWidth(d.maxWidth).Render("»" + strings.Join(s, " "))
+			return d.selectedItemStyle.Render("»" + strings.Join(s, " "))
 		}
 	}
 
-	fmt.Fprint(w, fn(string(item)))
+	text := string(item)
+	if d.indicator != nil {
+		if ind := d.indicator(text); ind != "" {
+			text += " " + ind
+		}
+	}
+
+	line := fn(text)
+	if d.dot != nil {
+		// Rendered separately, as the dot is colored independently of the item.
+		line = d.dot(string(item)) + line
+	}
+
+	fmt.Fprint(w, lipgloss.NewStyle().MaxWidth(d.maxWidth).Render(line))
 }
@@ -0,0 +1,95 @@
+package ui
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/labcoat/internal/runner"
+)
+
+const preflightTimeout = 30 * time.Second
+
+// Sent when deploy preflight checks have completed.
+type hostPreflightMsg struct {
+	host   *hostModel
+	checks []runner.PreflightCheck
+	ok     bool
+	deploy hostDeployMsg // Resent once the checks pass.
+}
+
+// hostPreflightCmd checks that the host is reachable before deploying to it.
+func (m *Model) hostPreflightCmd(host *hostModel, deploy hostDeployMsg) tea.Cmd {
+	host.deploy.preflighting = true
+	host.deploy.preflight = ""
+
+	intro := subtleStyle.Render("preflight checks @ "+host.transport.Destination()) + "\n"
+	host.deploy.contentPanel.SetContent(intro)
+
+	parent, t := m.ctx, host.transport
+	var sudo *runner.Sudo
+	if m.config.UseSudo(actionDeploy, host.target.DeployUser) {
+		sudo = host.sudo
+	}
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(parent, preflightTimeout)
+		defer cancel()
+
+		checks, ok := runner.Preflight(ctx, t, sudo)
+		return hostPreflightMsg{host: host, checks: checks, ok: ok, deploy: deploy}
+	}
+}
+
+func (m *Model) handleHostPreflightMsg(msg hostPreflightMsg) tea.Cmd {
+	host := msg.host
+	host.deploy.preflighting = false
+
+	var sb strings.Builder
+	sb.WriteString(subtleStyle.Render("preflight checks @ "+host.transport.Destination()) + "\n")
+	for _, c := range msg.checks {
+		sb.WriteString(renderPreflightCheck(c) + "\n")
+		if c.Failure == runner.FailureSudo {
+			// Password may be wrong, ask again next time.
+			host.sudo = nil
+		}
+	}
+	host.deploy.preflight = sb.String()
+
+	if !msg.ok {
+		host.deploy.contentPanel.SetContent(host.deploy.preflight)
+		return tea.Batch(func() tea.Msg {
+			return errorFlashMsg{text: "Deploy preflight checks failed for " + host.name}
+		}, m.runQueueCmd(host))
+	}
+
+	deploy := msg.deploy
+	deploy.preflighted = true
+	return func() tea.Msg { return deploy }
+}
+
+func renderPreflightCheck(c runner.PreflightCheck) string {
+	switch {
+	case c.Passed:
+		return labelSuccessStyle.Render(c.Name)
+	case c.Skipped:
+		return labelStyle.Render(c.Name) + " " + subtleStyle.Render("skipped")
+	}
+
+	s := labelFailedStyle.Render(c.Name)
+	if c.Failure != runner.FailureUnknown {
+		s += " " + c.Failure.String()
+	}
+	if c.Output != "" {
+		s += "\n" + c.Output
+	}
+
+	switch {
+	case c.Failure != runner.FailureUnknown:
+		s += "\n" + subtleStyle.Render(c.Failure.Hint())
+	case c.Name == "sudo":
+		s += "\n" + subtleStyle.Render("Deploying as a non-root user requires sudo access.")
+	}
+
+	return s
+}
@@ -0,0 +1,196 @@
+package ui
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Kinds of queued actions which only wait for target info.
+const (
+	queueActionMenu = "action menu"
+	queueNewSession = "new session"
+)
+
+// queuedAction is an action waiting for its host to become ready, ie for target info to arrive
+// or the current deploy to finish.
+type queuedAction struct {
+	kind  string  // Action, ie actionDeploy; determines when the host is ready.
+	label string  // Displayed in the queue indicator.
+	msg   tea.Msg // Sent once the host is ready.
+}
+
+// queueItem represents a queued action in the cancel menu, nil action for all.
+type queueItem struct {
+	action *queuedAction
+}
+
+func (item queueItem) FilterValue() string { return item.Title() }
+func (item queueItem) Title() string {
+	if item.action == nil {
+		return "All queued actions"
+	}
+	return item.action.label
+}
+func (item queueItem) Description() string {
+	if item.action == nil {
+		return "Cancel everything queued for this host"
+	}
+	return "Cancel " + item.action.kind
+}
+
+// requireTargetOrQueue is true if target info for host is available, otherwise it queues action
+// until it is.
+func (m *Model) requireTargetOrQueue(host *hostModel, action *queuedAction) (bool, tea.Cmd) {
+	if host == nil {
+		slog.Error(action.kind + " called with nil host (bug)")
+		return false, nil
+	}
+	if host.target != nil {
+		return true, nil
+	}
+
+	return false, m.enqueue(host, action, "target info is available")
+}
+
+// enqueue adds action to the queue of host, to run once the host is ready.  Only one deploy or
+// status action may be queued at a time.
+func (m *Model) enqueue(host *hostModel, action *queuedAction, until string) tea.Cmd {
+	for _, q := range host.queue {
+		if q.kind == action.kind && (q.kind == actionDeploy || q.kind == actionStatus) {
+			return func() tea.Msg {
+				return errorFlashMsg{text: fmt.Sprintf("%s of %q is already queued", q.label, host.name)}
+			}
+		}
+	}
+
+	host.queue = append(host.queue, action)
+	slog.Info("Queued action", "host", host.name, "action", action.kind, "until", until)
+
+	cmds := []tea.Cmd{func() tea.Msg {
+		return errorFlashMsg{text: fmt.Sprintf("Queued %s of %q until %s", action.label, host.name, until)}
+	}}
+	if host.target == nil && !host.targetPending {
+		cmds = append(cmds, m.hostTargetInfoCmd(host, nil))
+	}
+
+	return tea.Batch(cmds...)
+}
+
+// queued is true if an action of kind is queued for host.
+func (h *hostModel) queued(kind string) bool {
+	for _, q := range h.queue {
+		if q.kind == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readyFor is true if an action of kind may start on host.
+func (h *hostModel) readyFor(kind string) bool {
+	if h.target == nil {
+		return false
+	}
+
+	switch kind {
+	case actionDeploy:
+		return !h.deploy.preflighting && (h.deploy.runner == nil || !h.deploy.runner.Running())
+	case actionStatus:
+		return h.status.runner == nil || !h.status.runner.Running()
+	}
+
+	return true
+}
+
+// runQueueCmd starts the queued actions of host that are now ready, in the order queued.
+func (m *Model) runQueueCmd(host *hostModel) tea.Cmd {
+	if len(host.queue) == 0 {
+		return nil
+	}
+
+	var cmds []tea.Cmd
+	var remaining []*queuedAction
+	started := make(map[string]bool)
+	for _, q := range host.queue {
+		// Started actions only become busy once their message is handled.
+		if started[q.kind] || !host.readyFor(q.kind) {
+			remaining = append(remaining, q)
+			continue
+		}
+		started[q.kind] = true
+
+		slog.Info("Starting queued action", "host", host.name, "action", q.kind)
+		msg := q.msg
+		cmds = append(cmds, func() tea.Msg { return msg })
+	}
+	host.queue = remaining
+
+	return tea.Batch(cmds...)
+}
+
+// cancelQueuedCmd lets the user pick queued actions of host to cancel.
+func (m *Model) cancelQueuedCmd(host *hostModel) tea.Cmd {
+	if host == nil {
+		return nil
+	}
+	if len(host.queue) == 0 {
+		return func() tea.Msg {
+			return errorFlashMsg{text: fmt.Sprintf("Nothing queued for %q", host.name)}
+		}
+	}
+
+	items := make([]list.Item, 0, len(host.queue)+1)
+	if len(host.queue) > 1 {
+		items = append(items, queueItem{})
+	}
+	for _, q := range host.queue {
+		items = append(items, queueItem{action: q})
+	}
+
+	m.openMenu("Queued for "+host.name, "Select queued action: enter to cancel, esc to close",
+		items, func(item list.Item) tea.Cmd {
+			cancel := item.(queueItem).action
+			var remaining []*queuedAction
+			for _, q := range host.queue {
+				if cancel != nil && q != cancel {
+					remaining = append(remaining, q)
+				}
+			}
+			slog.Info("Cancelled queued actions", "host", host.name,
+				"count", len(host.queue)-len(remaining))
+			host.queue = remaining
+
+			return nil
+		})
+
+	return nil
+}
+
+// queueIndicator returns the host list indicator for queued actions, empty if there are none.
+func queueIndicator(host *hostModel) string {
+	if len(host.queue) == 0 {
+		return ""
+	}
+
+	return subtleStyle.Render("+" + strconv.Itoa(len(host.queue)))
+}
+
+// renderQueue describes the queued actions of host for the content footer.
+func renderQueue(host *hostModel) string {
+	if len(host.queue) == 0 {
+		return ""
+	}
+
+	labels := make([]string, 0, len(host.queue))
+	for _, q := range host.queue {
+		labels = append(labels, q.label)
+	}
+
+	return "queued: " + strings.Join(labels, ", ")
+}
@@ -0,0 +1,153 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/labcoat/internal/config"
+	"github.com/jhillyerd/labcoat/internal/nix"
+	"github.com/jhillyerd/labcoat/internal/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestModel returns a model for hostNames, recording history in a temporary directory.
+func newTestModel(t *testing.T, hostNames ...string) *Model {
+	t.Helper()
+
+	conf := config.Default()
+	conf.General.HistoryDir = t.TempDir()
+	m := New(conf, config.DefaultKeyMap, t.TempDir(), hostNames)
+	t.Cleanup(m.Close)
+
+	return &m
+}
+
+// setTarget emulates the arrival of target info for host, which runs commands with t.
+func setTarget(host *hostModel, t transport.Transport) {
+	host.targetPending = false
+	host.target = &nix.TargetInfo{DeployHost: host.name + ".example.com", DeployUser: "root"}
+	host.transport = t
+}
+
+// drive runs cmd, passing the resulting messages to m and running the commands they return, until
+// none remain.
+func drive(t *testing.T, m *Model, cmd tea.Cmd) {
+	t.Helper()
+
+	cmds := []tea.Cmd{cmd}
+	for i := 0; len(cmds) > 0; i++ {
+		require.Less(t, i, 100, "commands did not settle")
+
+		cmd, cmds = cmds[0], cmds[1:]
+		if cmd == nil {
+			continue
+		}
+		switch msg := cmd().(type) {
+		case nil:
+		case tea.BatchMsg:
+			// Run in order, so runner processes complete before their output is awaited.
+			cmds = append(msg, cmds...)
+		case errorFlashMsg:
+			// Clearing the flash waits on a timer.
+		default:
+			next, cmd := m.Update(msg)
+			*m = next.(Model)
+			cmds = append(cmds, cmd)
+		}
+	}
+}
+
+func TestRunCommandQueuedUntilTarget(t *testing.T) {
+	m := newTestModel(t, "web")
+	host := m.hosts["web"]
+
+	var mu sync.Mutex
+	var got []string
+	fake := &transport.Fake{
+		Dest: "ssh://root@web.example.com",
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			mu.Lock()
+			got = append(got, req.Cmd)
+			mu.Unlock()
+			fmt.Fprintln(req.Stdout, "up 3 days")
+			return nil
+		},
+	}
+
+	// Target info is fetched in the background, the command must wait for it.
+	m.handleHostRunCommandMsg(hostRunCommandMsg{host: host, action: actionRunCommand, prog: "uptime"})
+	require.Len(t, host.queue, 1)
+	assert.Equal(t, actionRunCommand, host.queue[0].kind)
+	assert.True(t, host.targetPending)
+	assert.Empty(t, got)
+
+	setTarget(host, fake)
+	drive(t, m, m.runQueueCmd(host))
+
+	assert.Empty(t, host.queue)
+	require.Len(t, got, 1)
+	assert.Contains(t, got[0], "uptime")
+
+	session := host.currentRunSession()
+	require.NotNil(t, session.runner)
+	assert.True(t, session.runner.Successful())
+	assert.Contains(t, session.runner.View(), "up 3 days")
+
+	records, err := m.history.List("web")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, actionRunCommand, records[0].Kind)
+	assert.Equal(t, "ssh://root@web.example.com", records[0].Destination)
+	assert.True(t, records[0].Successful)
+	output, err := m.history.Output(records[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(output), "up 3 days")
+}
+
+func TestRunCommandFailureRecorded(t *testing.T) {
+	m := newTestModel(t, "web")
+	host := m.hosts["web"]
+	setTarget(host, &transport.Fake{
+		Handler: func(_ context.Context, req transport.FakeRequest) error {
+			fmt.Fprintln(req.Stderr, "false: failed")
+			return transport.FakeExitError(1)
+		},
+	})
+
+	drive(t, m, m.hostRunCommandCmd(host, actionRunCommand, "false"))
+
+	session := host.currentRunSession()
+	require.NotNil(t, session.runner)
+	assert.False(t, session.runner.Successful())
+
+	records, err := m.history.List("web")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.False(t, records[0].Successful)
+	assert.Equal(t, 1, records[0].ExitCode)
+}
+
+func TestRebootQueuedUntilTarget(t *testing.T) {
+	m := newTestModel(t, "web", "db")
+	host := m.hosts["db"]
+	m.selectedHost = m.hosts["web"]
+	m.selectedHost.hostTab = hostTabRunCmd
+
+	m.confirmRebootCmd(host)
+	require.Len(t, host.queue, 1)
+	assert.Equal(t, actionReboot, host.queue[0].kind)
+	assert.Nil(t, m.confirmation)
+	assert.Equal(t, hostTabRunCmd, m.selectedHost.hostTab,
+		"fetching target info of another host must not switch tabs")
+
+	setTarget(host, &transport.Fake{})
+	drive(t, m, m.runQueueCmd(host))
+
+	assert.Empty(t, host.queue)
+	require.NotNil(t, m.confirmation)
+	assert.Contains(t, m.confirmation.text, "db.example.com")
+}
@@ -0,0 +1,224 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jhillyerd/labcoat/internal/reboot"
+)
+
+const rebootCommand = "/run/current-system/sw/bin/reboot"
+
+// Sent once the boot ID of a host to be rebooted has been read.
+type hostRebootMsg struct {
+	host   *hostModel
+	bootID string
+	err    error
+}
+
+// Sent when the reboot tracker reports a new phase.
+type hostRebootStatusMsg struct {
+	host    *hostModel
+	status  reboot.Status
+	updates <-chan reboot.Status
+}
+
+// Requests confirmation to reboot host, once target info is available.
+type hostRebootRequestMsg struct {
+	host *hostModel
+}
+
+// confirmRebootCmd asks the user to confirm a reboot of host, queued until target info is
+// available.
+func (m *Model) confirmRebootCmd(host *hostModel) tea.Cmd {
+	queued := &queuedAction{kind: actionReboot, label: "reboot", msg: hostRebootRequestMsg{host: host}}
+	if ok, cmd := m.requireTargetOrQueue(host, queued); !ok {
+		return cmd
+	}
+
+	reboot := m.hostRebootCmd(host)
+	dest := host.target.DeployHost
+	return func() tea.Msg {
+		return confirmationMsg{
+			text:   fmt.Sprintf("Confirm reboot of %q? y/n:", dest),
+			yesCmd: reboot,
+		}
+	}
+}
+
+// hostRebootCmd reboots the host, tracking it until it has booted when supported by the
+// transport.
+func (m *Model) hostRebootCmd(host *hostModel) tea.Cmd {
+	if host.reboot.tracking {
+		return func() tea.Msg {
+			return errorFlashMsg{text: fmt.Sprintf("Reboot of %q already in progress", host.name)}
+		}
+	}
+	if !trackReboot(host) {
+		return m.hostRunCommandCmd(host, actionReboot, rebootCommand)
+	}
+
+	// The boot ID tells us when the host is running again, even if it reboots quickly.
+	parent, t := m.ctx, host.transport
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(parent, preflightTimeout)
+		defer cancel()
+
+		id, err := reboot.BootID(ctx, t)
+		return hostRebootMsg{host: host, bootID: id, err: err}
+	}
+}
+
+func (m *Model) handleHostRebootMsg(msg hostRebootMsg) tea.Cmd {
+	host := msg.host
+	if msg.err != nil {
+		slog.Error("Failed to read boot id", "host", host.name, "err", msg.err)
+		return func() tea.Msg { return errorFlashMsg{text: "Reboot: " + msg.err.Error()} }
+	}
+
+	return m.handleHostRunCommandMsg(hostRunCommandMsg{
+		host:   host,
+		action: actionReboot,
+		prog:   rebootCommand,
+		bootID: msg.bootID,
+	})
+}
+
+// startRebootTracking polls the host until it has rebooted from bootID.
+func (m *Model) startRebootTracking(host *hostModel, bootID string) tea.Cmd {
+	var pingHost string
+	if host.target.JumpHost == "" {
+		pingHost = host.target.DeployHost
+	}
+	tracker := reboot.NewTracker(host.transport, bootID, pingHost)
+
+	ctx, cancel := context.WithTimeout(m.ctx, m.config.Timeouts.RebootWait.Std())
+	r := &host.reboot
+	r.tracking = true
+	r.cancel = cancel
+	r.statuses = nil
+	r.err = nil
+
+	slog.Info("Tracking reboot", "host", host.name, "boot_id", bootID, "ping", pingHost)
+
+	updates := make(chan reboot.Status, 1)
+	go tracker.Run(ctx, updates)
+
+	return waitForRebootStatus(host, updates)
+}
+
+func waitForRebootStatus(host *hostModel, updates <-chan reboot.Status) tea.Cmd {
+	return func() tea.Msg {
+		status, ok := <-updates
+		if !ok {
+			return nil
+		}
+
+		return hostRebootStatusMsg{host: host, status: status, updates: updates}
+	}
+}
+
+func (m *Model) handleHostRebootStatusMsg(msg hostRebootStatusMsg) tea.Cmd {
+	host := msg.host
+	r := &host.reboot
+	r.statuses = append(r.statuses, msg.status)
+	if r.session != nil {
+		m.renderRunSession(host, r.session)
+	}
+
+	if !msg.status.Final() {
+		return waitForRebootStatus(host, msg.updates)
+	}
+
+	r.tracking = false
+	r.cancel()
+	err := msg.status.Err
+	r.err = err
+	if err != nil {
+		slog.Warn("Reboot tracking failed", "host", host.name, "err", err)
+		return func() tea.Msg {
+			return errorFlashMsg{text: fmt.Sprintf("Reboot of %q: %v", host.name, err)}
+		}
+	}
+
+	slog.Info("Host rebooted", "host", host.name, "elapsed", msg.status.Elapsed)
+
+	// Refresh status now that the host is back.
+	return func() tea.Msg { return hostStatusRequestMsg{host: host, background: true} }
+}
+
+// trackReboot is true if reboots of host can be tracked.  Containers share the boot ID of the
+// machine running them, and rebooting the local machine ends labcoat.
+func trackReboot(host *hostModel) bool {
+	switch host.target.Transport {
+	case "ssh", "ssh-native":
+		return true
+	}
+
+	return false
+}
+
+// renderReboot renders the phases reached by the reboot tracker.
+func renderReboot(host *hostModel) string {
+	statuses := host.reboot.statuses
+	if len(statuses) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n" + labelStyle.Render("Reboot") + "\n")
+	for i, s := range statuses {
+		final := i == len(statuses)-1 && s.Final()
+		current := i == len(statuses)-1 && !final
+		elapsed := subtleStyle.Render(s.Elapsed.Round(time.Second).String())
+
+		switch {
+		case final && s.Err != nil:
+			sb.WriteString(lipgloss.NewStyle().Foreground(failedColor).Render("✗ "+s.Phase.String()) +
+				" " + elapsed + "\n" + s.Err.Error() + "\n")
+		case current:
+			sb.WriteString("… " + s.Phase.String() + " " + elapsed + "\n")
+		default:
+			sb.WriteString("✓ " + s.Phase.String() + " " + elapsed + "\n")
+		}
+
+		if s.Result != nil {
+			sb.WriteString(renderRebootResult(s.Result))
+		}
+	}
+
+	return sb.String()
+}
+
+func renderRebootResult(r *reboot.Result) string {
+	s := "uptime: " + r.Uptime + "\n"
+	if r.BootedSystem != "" {
+		s += "booted generation: " + r.BootedSystem + "\n"
+	}
+	if r.GenerationMismatch() {
+		s += lipgloss.NewStyle().Foreground(errorColor).
+			Render("current generation differs: "+r.CurrentSystem) + "\n"
+	}
+
+	return s
+}
+
+// rebootIndicator returns the host list indicator for host, empty when there is nothing to show.
+func rebootIndicator(host *hostModel) string {
+	r := &host.reboot
+	switch {
+	case r.tracking && len(r.statuses) > 0:
+		return subtleStyle.Render("↻ " + r.statuses[len(r.statuses)-1].Phase.String())
+	case r.tracking:
+		return subtleStyle.Render("↻")
+	case r.err != nil:
+		return lipgloss.NewStyle().Foreground(failedColor).Render("✗ reboot")
+	}
+
+	return ""
+}
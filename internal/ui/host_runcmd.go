@@ -3,82 +3,246 @@ package ui
 import (
 	"log/slog"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/jhillyerd/labcoat/internal/runner"
 )
 
+// Actions that may be configured to use sudo, see config.Config.UseSudo.
+const (
+	actionDeploy     = "deploy"
+	actionStatus     = "status"
+	actionReboot     = "reboot"
+	actionRunCommand = "run-command"
+)
+
 type hostRunCommandMsg struct {
-	host *hostModel
-	prog string
-	args []string
+	host   *hostModel
+	action string // actionReboot or actionRunCommand.
+	prog   string
+	args   []string
+	script string        // Run on the host with its shell instead of prog.
+	local  bool          // Run prog in the flake directory instead of on the host.
+	label  string        // Displayed instead of the command line when set.
+	bootID string        // Boot ID before a reboot, tracked once the command starts.
+	watch  time.Duration // Re-run the command at this interval, see watch.
+	input  bool          // Keep stdin open, attaching input once started.
+
+	// Watched session to re-run the command in, without displaying it.
+	into *runSession
+
+	// Named session to run in, see runSessionFor.  An idle or new session is used when empty.
+	session string
 }
 
 // Sent when the runner has new output/status to display.
 type hostRunCommandOutputMsg struct {
-	host  *hostModel
-	final bool
+	host    *hostModel
+	session *runSession
+	final   bool
 }
 
-func (m *Model) hostRunCommandCmd(host *hostModel, prog string, args ...string) tea.Cmd {
+func (m *Model) hostRunCommandCmd(host *hostModel, action string, prog string, args ...string) tea.Cmd {
 	return func() tea.Msg {
 		return hostRunCommandMsg{
-			host: host,
-			prog: prog,
-			args: args,
+			host:   host,
+			action: action,
+			prog:   prog,
+			args:   args,
 		}
 	}
 }
 
 func (m *Model) handleHostRunCommandMsg(msg hostRunCommandMsg) tea.Cmd {
 	host := msg.host
-	if ok, cmd := requireHostTarget("hostRunCommand", host); !ok {
+	queued := &queuedAction{kind: msg.action, label: "run " + firstLine(msg.prog), msg: msg}
+	if msg.script != "" || msg.local {
+		queued.label = "run " + msg.session
+	}
+	if ok, cmd := m.requireTargetOrQueue(host, queued); !ok {
 		return cmd
 	}
 
-	m.setVisibleHostTab(hostTabRunCmd)
-
-	if host.runCmd.runner != nil && host.runCmd.runner.Running() {
-		slog.Info("host cmd already running", "host", host.name)
+	if msg.into != nil && (msg.into.watch == nil || msg.into.running()) {
+		// Stopped watching before the re-run started.
 		return nil
 	}
+	if msg.into == nil {
+		m.setVisibleHostTab(hostTabRunCmd)
+	}
+
+	sudo := !msg.local && m.config.UseSudo(msg.action, host.target.DeployUser)
+	if sudo && host.sudo == nil {
+		return m.requireSudo(host, msg)
+	}
 
+	session := msg.into
+	if session == nil {
+		session = m.runSessionFor(host, msg)
+		session.msg = msg
+		session.watch = nil
+		if msg.watch > 0 {
+			session.watch = &watch{interval: msg.watch}
+		}
+	} else if session.runner != nil {
+		session.watch.prev = strings.Split(session.runner.View(), "\n")
+	}
 	onUpdate := func(r *runner.Model) tea.Msg {
-		return hostRunCommandOutputMsg{host: host, final: r.Complete()}
+		return hostRunCommandOutputMsg{host: host, session: session, final: r.Complete()}
 	}
 
-	srunner := runner.NewRemote(m.ctx, onUpdate,
-		host.target.DeployHost, host.target.DeployUser,
-		msg.prog, msg.args...)
+	timeout := m.config.Timeouts.RunCommand.Std()
+	if msg.action == actionReboot {
+		timeout = m.config.Timeouts.Reboot.Std()
+	}
+
+	var srunner *runner.Model
+	var err error
+	label := msg.label
+	switch {
+	case msg.local:
+		srunner = m.newLocalRunner(m.ctx, onUpdate, msg.prog, msg.args...)
+		for _, env := range m.actionEnv(host) {
+			srunner.SetEnv(env[0], env[1])
+		}
+	case msg.script != "":
+		// Script is read from stdin, so input cannot be attached.
+		prologue, script, secret := "", msg.script, ""
+		if sudo {
+			var cmds []runner.ScriptCmd
+			prologue, cmds = host.sudo.WrapScript([]runner.ScriptCmd{{Cmd: script}})
+			secret = host.sudo.Secret()
+			script = cmds[0].Cmd
+		}
+		srunner, err = runner.NewRemoteScript(m.ctx, onUpdate, host.transport, host.target.Shell,
+			msg.label, secret, prologue+script+"\n")
+	case sudo:
+		cmdline := strings.Join(append([]string{msg.prog}, msg.args...), " ")
+		srunner, err = runner.NewRemote(m.ctx, onUpdate, host.transport, host.sudo.Wrap(cmdline))
+		if label == "" {
+			label = "sudo " + cmdline
+		}
+	default:
+		srunner, err = runner.NewRemote(m.ctx, onUpdate, host.transport, msg.prog, msg.args...)
+	}
+	if err != nil {
+		slog.Error("Failed to create runner", "host", host.name, "err", err)
+		return func() tea.Msg { return errorFlashMsg{text: "Run: " + err.Error()} }
+	}
+	if label != "" {
+		srunner.SetLabel(label)
+	}
+	if sudo {
+		srunner.SetSudo(host.sudo)
+	}
+	srunner.SetTimeout(timeout)
+	if msg.action == actionReboot {
+		// Connection is expected to drop.
+		srunner.SetDisconnectOK()
+	}
+	var attach bool
+	switch {
+	case msg.script != "":
+		// Script is read from stdin, so input cannot be attached.
+	case msg.input:
+		if err := srunner.EnableInput(); err != nil {
+			slog.Error("Failed to enable runner input", "host", host.name, "err", err)
+			break
+		}
+		attach = msg.into == nil
+		if sudo {
+			// Password must precede any user input.
+			if err := srunner.WriteInput(host.sudo.Input()); err != nil {
+				slog.Error("Failed to write sudo password", "host", host.name, "err", err)
+			}
+		}
+	case sudo:
+		srunner.SetInput(host.sudo.Input())
+	}
 	srunner.Styles.StatusSuffix = subtleStyle
-	host.runCmd.runner = srunner
+	session.runner = srunner
+	session.notice = notice{}
+	var revCmd tea.Cmd
+	if session.watch != nil {
+		// Each run would flood history and notifications.
+		session.history = historyRun{recorded: true}
+		session.notice.sent = true
+	} else {
+		session.history, revCmd = m.newHistoryRun(msg.action, srunner.Destination())
+	}
+	start := tea.Batch(srunner.Init(), revCmd)
+	if attach {
+		start = tea.Batch(start, m.attachStartedInput(srunner))
+	}
 
 	// Init status display.
-	intro := lipgloss.NewStyle().
-		Foreground(subtleColor).
-		Render(srunner.String()+" @ "+srunner.Destination()) + "\n"
-	host.runCmd.intro = intro
-	host.runCmd.contentPanel.SetContent(intro)
+	intro := srunner.String() + " @ " + srunner.Destination()
+	if session.watch != nil {
+		intro = "Every " + session.watch.interval.String() + ": " + intro
+	}
+	intro = lipgloss.NewStyle().Foreground(subtleColor).Render(intro) + "\n"
+	session.intro = intro
+	if msg.into == nil {
+		// Watched output is replaced once the new run has output.
+		session.contentPanel.SetContent(intro)
+	}
+
+	if host.reboot.tracking {
+		return start
+	}
+	if host.reboot.session == session {
+		// Previous reboot is no longer displayed.
+		host.reboot.session = nil
+		host.reboot.statuses = nil
+		host.reboot.err = nil
+	}
+	if msg.bootID == "" {
+		return start
+	}
+	host.reboot.session = session
 
-	return srunner.Init()
+	return tea.Batch(start, m.startRebootTracking(host, msg.bootID))
 }
 
 func (m *Model) handleHostRunCommandOutputMsg(msg hostRunCommandOutputMsg) tea.Cmd {
-	host := msg.host
-	if host.runCmd.runner == nil {
-		slog.Error("Received hostCmdOutput for host with no runner (bug)", "host", host.name)
+	host, session := msg.host, msg.session
+	if session.runner == nil {
+		slog.Error("Received hostCmdOutput for session with no runner (bug)", "host", host.name)
 		return nil
 	}
 
-	srunner := host.runCmd.runner
+	// Session may have been closed, its runner continues until cancelled.
+	srunner := session.runner
 	_, cmd := srunner.Update(nil)
 
-	// Render and cache output content.
-	output := host.runCmd.intro
-	output += runner.FormatOutput(
-		srunner.View(),
-		func(s string) string { return labelStyle.Render(s) })
+	if msg.final {
+		m.releaseRunnerInput(srunner)
+		cmd = tea.Batch(cmd, m.runnerFailureCmd(host, srunner),
+			m.recordHistoryCmd(host, srunner, &session.history),
+			m.notifyCmd(host, session.msg.action, srunner, &session.notice),
+			scheduleWatchCmd(host, session))
+	}
+
+	m.renderRunSession(host, session)
+	return cmd
+}
+
+// renderRunSession renders and caches the content of a Run Command session, including reboot
+// progress.
+func (m *Model) renderRunSession(host *hostModel, session *runSession) {
+	output := session.intro
+	if session.runner != nil {
+		if w := session.watch; w != nil && w.prev != nil {
+			output += highlightChanges(session.runner.View(), w.prev)
+		} else {
+			output += session.runner.View()
+		}
+	}
+	if host.reboot.session == session {
+		output += renderReboot(host)
+	}
 
 	// Carriage returns cause formatting issues.
 	output = strings.ReplaceAll(output, "\r", "")
@@ -88,6 +252,5 @@ func (m *Model) handleHostRunCommandOutputMsg(msg hostRunCommandOutputMsg) tea.C
 	// TODO configurable line wrapping?
 	output = lipgloss.NewStyle().MaxWidth(m.sizes.contentPanel.width).Render(output)
 
-	host.runCmd.contentPanel.SetContent(output)
-	return cmd
+	session.contentPanel.SetContent(output)
 }
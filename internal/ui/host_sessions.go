@@ -0,0 +1,279 @@
+package ui
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/labcoat/internal/runner"
+)
+
+const maxSessionNameLen = 20
+
+// runSession is a named command session in the Run Command tab, each host has at least one.
+type runSession struct {
+	name         string // Empty until a command has run.
+	named        bool   // Name was chosen by the user, rather than the command.
+	intro        string // Rendered intro text: command, host, etc.
+	contentPanel viewport.Model
+	runner       *runner.Model
+	history      historyRun
+	notice       notice
+	msg          hostRunCommandMsg // Most recent command, to run it again.
+	watch        *watch            // Re-runs the command, nil if not watching.
+}
+
+// Requests that a run session be closed, cancelling its command.
+type runSessionCloseMsg struct {
+	host    *hostModel
+	session *runSession
+}
+
+// Requests a prompt for a new run session on host, once target info is available.
+type newSessionRequestMsg struct {
+	host *hostModel
+}
+
+func (s *runSession) running() bool {
+	return s.runner != nil && s.runner.Running()
+}
+
+// sessionBusy is true if session may not be reused for another command, as it is running, watching
+// a command, or displaying reboot progress.
+func (h *hostModel) sessionBusy(session *runSession) bool {
+	return session.running() || session.watch != nil ||
+		h.reboot.tracking && h.reboot.session == session
+}
+
+// hasRunSession is true if session belongs to host, ie it has not been closed.
+func (h *hostModel) hasRunSession(session *runSession) bool {
+	for _, s := range h.runCmd.sessions {
+		if s == session {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Model) newRunSession(name string) *runSession {
+	return &runSession{
+		name:         name,
+		named:        name != "",
+		contentPanel: newContentPanel(m.keys),
+	}
+}
+
+// currentRunSession returns the visible session of host.
+func (h *hostModel) currentRunSession() *runSession {
+	return h.runCmd.sessions[h.runCmd.current]
+}
+
+// runSessionFor returns the session to run msg in, and makes it current.  The current session is
+// reused if it is idle, otherwise a new session is created.  A requested session name reuses an
+// idle session of the same name.
+func (m *Model) runSessionFor(host *hostModel, msg hostRunCommandMsg) *runSession {
+	rc := &host.runCmd
+	session := func() *runSession {
+		if cur := host.currentRunSession(); msg.session == "" && !host.sessionBusy(cur) {
+			return cur
+		}
+		if msg.session != "" {
+			for i, s := range rc.sessions {
+				if s.name == msg.session && !host.sessionBusy(s) {
+					rc.current = i
+					return s
+				}
+			}
+		}
+
+		s := m.newRunSession("")
+		rc.sessions = append(rc.sessions, s)
+		rc.current = len(rc.sessions) - 1
+
+		return s
+	}()
+	m.updateContentPanel()
+
+	if msg.session != "" {
+		session.named = true
+	}
+	if !session.named || session.name == "" {
+		name := msg.session
+		if name == "" {
+			name = autoSessionName(msg)
+		}
+		session.name = uniqueSessionName(host, session, name)
+	}
+
+	return session
+}
+
+// autoSessionName names a session after the program it runs.
+func autoSessionName(msg hostRunCommandMsg) string {
+	if msg.script != "" {
+		return "script"
+	}
+
+	name := path.Base(strings.Fields(msg.prog + " x")[0])
+	if len(name) > maxSessionNameLen {
+		name = name[:maxSessionNameLen]
+	}
+
+	return name
+}
+
+// uniqueSessionName returns name, suffixed if another session of host is using it.
+func uniqueSessionName(host *hostModel, session *runSession, name string) string {
+	taken := func(candidate string) bool {
+		for _, s := range host.runCmd.sessions {
+			if s != session && s.name == candidate {
+				return true
+			}
+		}
+		return false
+	}
+
+	candidate := name
+	for i := 2; taken(candidate); i++ {
+		candidate = name + "#" + strconv.Itoa(i)
+	}
+
+	return candidate
+}
+
+// newRunSessionCmd prompts for a session name, then for the command to run in it.
+func (m *Model) newRunSessionCmd(host *hostModel) tea.Cmd {
+	queued := &queuedAction{
+		kind:  queueNewSession,
+		label: "new session",
+		msg:   newSessionRequestMsg{host: host},
+	}
+	if ok, cmd := m.requireTargetOrQueue(host, queued); !ok {
+		return cmd
+	}
+
+	return func() tea.Msg {
+		return textInputPromptMsg{
+			prompt: "New session name: ",
+			submitFn: func(name string) tea.Cmd {
+				name = strings.TrimSpace(name)
+				if name == "" {
+					return nil
+				}
+				return func() tea.Msg {
+					return textInputPromptMsg{
+						prompt: fmt.Sprintf("Run on %q in %q: ", host.target.DeployHost, name),
+						submitFn: func(cmdline string) tea.Cmd {
+							return func() tea.Msg {
+								return hostRunCommandMsg{
+									host:    host,
+									action:  actionRunCommand,
+									prog:    cmdline,
+									session: name,
+								}
+							}
+						},
+					}
+				}
+			},
+		}
+	}
+}
+
+// switchRunSession displays the session delta places from the current session of host.
+func (m *Model) switchRunSession(host *hostModel, delta int) {
+	if host == nil {
+		return
+	}
+
+	rc := &host.runCmd
+	n := len(rc.sessions)
+	if host.hostTab == hostTabRunCmd {
+		rc.current = ((rc.current+delta)%n + n) % n
+	}
+	if m.runnerInput != nil {
+		m.detachRunnerInput()
+	}
+	m.setVisibleHostTab(hostTabRunCmd)
+}
+
+// closeRunSessionCmd closes the visible session of host, confirming first if it is running.
+func (m *Model) closeRunSessionCmd(host *hostModel) tea.Cmd {
+	if host == nil {
+		return nil
+	}
+	m.setVisibleHostTab(hostTabRunCmd)
+
+	session := host.currentRunSession()
+	closeSession := func() tea.Msg { return runSessionCloseMsg{host: host, session: session} }
+	if !session.running() {
+		return closeSession
+	}
+
+	return func() tea.Msg {
+		return confirmationMsg{
+			text:   fmt.Sprintf("Cancel %q and close session? y/n:", session.runner.String()),
+			yesCmd: closeSession,
+		}
+	}
+}
+
+func (m *Model) handleRunSessionCloseMsg(msg runSessionCloseMsg) tea.Cmd {
+	host, session := msg.host, msg.session
+	stopWatch(session)
+	if session.running() {
+		// Output continues to be recorded in history.
+		session.runner.Cancel()
+	}
+	if session.runner != nil {
+		m.releaseRunnerInput(session.runner)
+	}
+	if host.reboot.session == session {
+		host.reboot.session = nil
+	}
+
+	rc := &host.runCmd
+	for i, s := range rc.sessions {
+		if s == session {
+			rc.sessions = append(rc.sessions[:i], rc.sessions[i+1:]...)
+			if rc.current >= i && rc.current > 0 {
+				rc.current--
+			}
+			break
+		}
+	}
+	if len(rc.sessions) == 0 {
+		rc.sessions = append(rc.sessions, m.newRunSession(""))
+		rc.current = 0
+	}
+	m.updateContentPanel()
+
+	return nil
+}
+
+// renderRunSessions renders the session names of host for the content footer, empty if there is
+// only one.
+func renderRunSessions(host *hostModel) string {
+	rc := &host.runCmd
+	if len(rc.sessions) < 2 {
+		return ""
+	}
+
+	names := make([]string, 0, len(rc.sessions))
+	for i, s := range rc.sessions {
+		name := strconv.Itoa(i+1) + ":" + s.name
+		if s.running() || s.watch != nil {
+			name += "*"
+		}
+		if i == rc.current {
+			name = "[" + name + "]"
+		}
+		names = append(names, name)
+	}
+
+	return strings.Join(names, " ")
+}
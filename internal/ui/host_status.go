@@ -3,51 +3,120 @@ package ui
 import (
 	"log/slog"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/jhillyerd/labcoat/internal/runner"
 )
 
+// Requests a status refresh, ie once sudo is authenticated.
+type hostStatusRequestMsg struct {
+	host       *hostModel
+	background bool // Do not switch to the Host Status tab.
+}
+
 type hostStatusMsg struct {
 	hostName string
 	final    bool // Indicates last status update message.
 }
 
 func (m *Model) hostStatusCmd(host *hostModel) tea.Cmd {
-	if ok, cmd := requireHostTarget("hostStatusCmd", host); !ok {
+	return m.startHostStatus(host, true)
+}
+
+// startHostStatus collects the status of host, switching to the Host Status tab if show is true and
+// host is selected.
+func (m *Model) startHostStatus(host *hostModel, show bool) tea.Cmd {
+	return m.collectHostStatus(host, show, false)
+}
+
+// collectHostStatus implements startHostStatus.  When refresh is true, the previous status remains
+// displayed until collection completes.
+func (m *Model) collectHostStatus(host *hostModel, show bool, refresh bool) tea.Cmd {
+	queued := &queuedAction{
+		kind:  actionStatus,
+		label: "status",
+		msg:   hostStatusRequestMsg{host: host, background: !show},
+	}
+	if ok, cmd := m.requireTargetOrQueue(host, queued); !ok {
 		return cmd
 	}
 
-	m.setVisibleHostTab(hostTabStatus)
+	if show && host == m.selectedHost {
+		m.setVisibleHostTab(hostTabStatus)
+	}
 
 	// Do nothing if status job is already running.
-	srunner := m.selectedHost.status.runner
+	srunner := host.status.runner
 	if srunner != nil && srunner.Running() {
 		slog.Debug("hostStatusCmd already running", "host", host.name)
 		return nil
 	}
 
+	sudo := m.config.UseSudo(actionStatus, host.target.DeployUser)
+	if sudo && host.sudo == nil {
+		return m.requireSudo(host, hostStatusRequestMsg{host: host, background: !show})
+	}
+
 	onUpdate := func(r *runner.Model) tea.Msg {
 		// Sent when the runner has new output to display.
 		return hostStatusMsg{hostName: host.name, final: r.Complete()}
 	}
 
-	script := runner.NewScript(m.config.Commands.StatusCmds)
-	srunner = runner.NewRemoteScript(m.ctx, onUpdate,
-		host.target.DeployHost, host.target.DeployUser, "host status (script)", script)
+	cmds := make([]runner.ScriptCmd, 0, len(m.config.Commands.StatusCmds))
+	for _, cmd := range m.config.Commands.StatusCmds {
+		cmds = append(cmds, runner.ScriptCmd{
+			Cmd:     cmd,
+			Timeout: m.config.Commands.StatusTimeout(cmd),
+		})
+	}
+
+	name, prologue, secret := "host status (script)", "", ""
+	if sudo {
+		name = "host status (sudo script)"
+		prologue, cmds = host.sudo.WrapScript(cmds)
+		secret = host.sudo.Secret()
+	}
+
+	script, err := runner.NewScript(cmds)
+	if err == nil {
+		srunner, err = runner.NewRemoteScript(m.ctx, onUpdate, host.transport, host.target.Shell,
+			name, secret, prologue+script.String())
+	}
+	if err != nil {
+		slog.Error("Failed to create status runner", "host", host.name, "err", err)
+		return func() tea.Msg { return errorFlashMsg{text: "Status: " + err.Error()} }
+	}
+	if sudo {
+		srunner.SetSudo(host.sudo)
+	}
+	srunner.SetTimeout(m.config.Timeouts.Status.Std())
 	srunner.Styles.StatusSuffix = subtleStyle
 
 	host.status.runner = srunner
+	host.status.notice = notice{}
+	host.status.parser = script.NewParser()
+	host.status.parsed = 0
+	host.status.refreshing = refresh
 
 	// Init status display.
 	intro := lipgloss.NewStyle().
 		Foreground(subtleColor).
 		Render(srunner.String()+" @ "+srunner.Destination()) + "\n"
+	var offer tea.Cmd
+	if host.status.staleKeys {
+		intro = renderStaleKnownHosts(host.target.DeployHost) + intro
+		if show && host == m.selectedHost {
+			offer = m.offerStaleKnownHostsCmd(host)
+		}
+	}
 	host.status.intro = intro
-	host.status.contentPanel.SetContent(intro)
+	if !refresh {
+		host.status.contentPanel.SetContent(intro)
+	}
 
-	return srunner.Init()
+	return tea.Batch(srunner.Init(), offer)
 }
 
 func (m *Model) handleHostStatusMsg(msg hostStatusMsg) tea.Cmd {
@@ -61,15 +130,30 @@ func (m *Model) handleHostStatusMsg(msg hostStatusMsg) tea.Cmd {
 	srunner := host.status.runner
 	_, cmd := srunner.Update(nil)
 
+	// Parse only the output written since the last update.
+	n, err := srunner.CopyFrom(host.status.parser, host.status.parsed)
+	host.status.parsed += n
+	if err != nil {
+		slog.Error("Failed to parse status output", "host", msg.hostName, "err", err)
+	}
+	if msg.final {
+		host.status.parser.Flush()
+	}
+
 	if msg.final {
 		host.status.collected = srunner.Successful()
+		cmd = tea.Batch(cmd, m.runnerFailureCmd(host, srunner),
+			m.notifyCmd(host, actionStatus, srunner, &host.status.notice), m.runQueueCmd(host))
+		host.status.refreshing = false
+	}
+	if host.status.refreshing {
+		// Avoid flicker, the previous status is replaced once complete.
+		return cmd
 	}
 
 	// Render and cache status content.
 	status := host.status.intro
-	status += runner.FormatOutput(
-		srunner.View(),
-		func(s string) string { return labelStyle.Render(s) })
+	status += host.status.parser.Format(renderSectionLabel)
 
 	// Carriage returns cause formatting issues.
 	status = strings.ReplaceAll(status, "\r", "")
@@ -83,3 +167,34 @@ func (m *Model) handleHostStatusMsg(msg hostStatusMsg) tea.Cmd {
 
 	return cmd
 }
+
+// Sent every statusRefreshTick while commands.status-refresh is enabled.
+type statusRefreshMsg struct{}
+
+const statusRefreshTick = time.Second
+
+func statusRefreshTickCmd() tea.Cmd {
+	return tea.Tick(statusRefreshTick, func(time.Time) tea.Msg { return statusRefreshMsg{} })
+}
+
+// handleStatusRefreshMsg re-collects the status of the selected host while its Host Status tab is
+// visible, once the previous collection is older than the refresh interval.
+func (m *Model) handleStatusRefreshMsg(_ statusRefreshMsg) tea.Cmd {
+	next := statusRefreshTickCmd()
+
+	host := m.selectedHost
+	if m.viewMode != viewModeHosts || host == nil || host.hostTab != hostTabStatus ||
+		host.target == nil {
+		return next
+	}
+	r := host.status.runner
+	if r == nil || r.Running() || time.Since(r.Finished()) < m.config.Commands.StatusRefresh.Std() {
+		return next
+	}
+	if m.config.UseSudo(actionStatus, host.target.DeployUser) && host.sudo == nil {
+		// Never prompt for a password unprompted.
+		return next
+	}
+
+	return tea.Batch(next, m.collectHostStatus(host, false, true))
+}
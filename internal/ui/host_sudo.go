@@ -0,0 +1,83 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/labcoat/internal/runner"
+)
+
+// Sent when we have determined whether sudo requires a password on a host.
+type hostSudoCheckedMsg struct {
+	host     *hostModel
+	required bool
+	err      error
+	retry    tea.Msg // Resent once sudo is ready.
+}
+
+// requireSudo determines how to authenticate sudo on the host, prompting for a password if
+// required, then resends retry.
+func (m *Model) requireSudo(host *hostModel, retry tea.Msg) tea.Cmd {
+	parent, t := m.ctx, host.transport
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(parent, preflightTimeout)
+		defer cancel()
+
+		required, err := runner.SudoRequiresPassword(ctx, t)
+		return hostSudoCheckedMsg{host: host, required: required, err: err, retry: retry}
+	}
+}
+
+func (m *Model) handleHostSudoCheckedMsg(msg hostSudoCheckedMsg) tea.Cmd {
+	host := msg.host
+	if msg.err != nil {
+		slog.Error("Sudo check failed", "host", host.name, "err", msg.err)
+		return func() tea.Msg { return errorFlashMsg{text: "Sudo: " + msg.err.Error()} }
+	}
+
+	retry := func() tea.Msg { return msg.retry }
+	if !msg.required {
+		host.sudo = &runner.Sudo{}
+		return retry
+	}
+
+	return func() tea.Msg {
+		return textInputPromptMsg{
+			prompt: fmt.Sprintf("[sudo] password for %s@%s: ",
+				host.target.DeployUser, host.target.DeployHost),
+			masked: true,
+			submitFn: func(password string) tea.Cmd {
+				host.sudo = &runner.Sudo{Password: password}
+				return retry
+			},
+		}
+	}
+}
+
+// runnerFailureCmd responds to recognized failures of a completed runner for host.
+func (m *Model) runnerFailureCmd(host *hostModel, r *runner.Model) tea.Cmd {
+	switch r.Failure() {
+	case runner.FailureHostKey:
+		if !r.Remote() {
+			// Local commands such as nixos-rebuild may connect to build or jump hosts, the failing
+			// host is unknown.
+			return nil
+		}
+		return m.hostKeyFailureCmd(host)
+
+	case runner.FailureSudo:
+		if host.sudo == nil {
+			return nil
+		}
+
+		// Password may be wrong, ask again next time.
+		host.sudo = nil
+		return func() tea.Msg {
+			return errorFlashMsg{text: fmt.Sprintf("Sudo authentication failed for %q", host.name)}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const minWatchInterval = 100 * time.Millisecond
+
+var watchChangedStyle = lipgloss.NewStyle().Reverse(true)
+
+// watch re-runs the command of a Run Command session periodically, like watch(1).
+type watch struct {
+	interval time.Duration
+	prev     []string // Output lines of the previous run, nil until a run completes.
+	pending  bool     // A re-run is scheduled.
+}
+
+// Sent when a watched session is due to run again.
+type runSessionWatchMsg struct {
+	host    *hostModel
+	session *runSession
+	watch   *watch // Ignored unless still watching the session.
+}
+
+// watchPromptCmd prompts for a command to watch on host.
+func (m *Model) watchPromptCmd(host *hostModel) tea.Cmd {
+	if host == nil {
+		return nil
+	}
+
+	interval := m.config.Commands.WatchInterval.Std()
+	dest := host.name + " (queued)"
+	if host.target != nil {
+		dest = host.target.DeployHost
+	}
+
+	return func() tea.Msg {
+		return textInputPromptMsg{
+			prompt: fmt.Sprintf("Watch on %q every %s, or -n SECS: ", dest, interval),
+			submitFn: func(cmdline string) tea.Cmd {
+				every, cmdline, err := parseWatchCmd(cmdline, interval)
+				if err != nil {
+					return func() tea.Msg { return errorFlashMsg{text: "Watch: " + err.Error()} }
+				}
+				if cmdline == "" {
+					return nil
+				}
+				return func() tea.Msg {
+					return hostRunCommandMsg{
+						host:   host,
+						action: actionRunCommand,
+						prog:   cmdline,
+						watch:  every,
+					}
+				}
+			},
+		}
+	}
+}
+
+// parseWatchCmd splits an optional `-n SECS` interval prefix from cmdline.
+func parseWatchCmd(cmdline string, interval time.Duration) (time.Duration, string, error) {
+	cmdline = strings.TrimSpace(cmdline)
+	rest, ok := strings.CutPrefix(cmdline, "-n")
+	if !ok || rest == "" || rest[0] != ' ' {
+		return interval, cmdline, nil
+	}
+
+	secs, rest, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	f, err := strconv.ParseFloat(secs, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid interval %q", secs)
+	}
+	interval = time.Duration(f * float64(time.Second))
+	if interval < minWatchInterval {
+		return 0, "", fmt.Errorf("interval must be at least %s", minWatchInterval)
+	}
+
+	return interval, strings.TrimSpace(rest), nil
+}
+
+// scheduleWatchCmd runs the watched command of session again after its interval.
+func scheduleWatchCmd(host *hostModel, session *runSession) tea.Cmd {
+	w := session.watch
+	if w == nil || w.pending {
+		return nil
+	}
+	w.pending = true
+
+	return tea.Tick(w.interval, func(time.Time) tea.Msg {
+		return runSessionWatchMsg{host: host, session: session, watch: w}
+	})
+}
+
+func (m *Model) handleRunSessionWatchMsg(msg runSessionWatchMsg) tea.Cmd {
+	host, session := msg.host, msg.session
+	if session.watch != msg.watch {
+		// Stopped watching, or watching another command.
+		return nil
+	}
+	session.watch.pending = false
+	if session.running() || !host.hasRunSession(session) {
+		return nil
+	}
+
+	rerun := session.msg
+	rerun.into = session
+	return func() tea.Msg { return rerun }
+}
+
+// stopWatch stops re-running the command of session, if it is being watched.
+func stopWatch(session *runSession) {
+	session.watch = nil
+}
+
+// highlightChanges highlights the lines of output that differ from the same line of prev.
+func highlightChanges(output string, prev []string) string {
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		if line == "" && i == len(lines)-1 {
+			// Trailing newline.
+			break
+		}
+		if i >= len(prev) || prev[i] != line {
+			lines[i] = watchChangedStyle.Render(line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
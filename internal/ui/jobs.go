@@ -0,0 +1,251 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jhillyerd/labcoat/internal/runner"
+)
+
+// job is a runner belonging to a host, listed in the Jobs view.
+type job struct {
+	host    *hostModel
+	kind    string      // Action, or run session name.
+	tab     int         // Host tab displaying the output.
+	session *runSession // Run Command session, nil for status & deploy.
+	runner  *runner.Model
+}
+
+// jobs returns every runner across all hosts, ordered by host, then tab.  Order must remain stable
+// as jobs complete, so the cursor does not jump.
+func (m *Model) jobs() []job {
+	names := make([]string, 0, len(m.hosts))
+	for name := range m.hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var jobs []job
+	for _, name := range names {
+		host := m.hosts[name]
+		if r := host.status.runner; r != nil {
+			jobs = append(jobs, job{host: host, kind: actionStatus, tab: hostTabStatus, runner: r})
+		}
+		if r := host.deploy.runner; r != nil {
+			jobs = append(jobs, job{host: host, kind: actionDeploy, tab: hostTabDeploy, runner: r})
+		}
+		for _, s := range host.runCmd.sessions {
+			if s.runner == nil {
+				continue
+			}
+			kind := "run: " + s.name
+			if s.msg.action == actionReboot {
+				kind = actionReboot
+			}
+			jobs = append(jobs, job{host: host, kind: kind, tab: hostTabRunCmd, session: s, runner: s.runner})
+		}
+	}
+
+	return jobs
+}
+
+// openJobs displays the Jobs view.
+func (m *Model) openJobs() tea.Cmd {
+	m.viewMode = viewModeJobs
+	m.jobCursor = 0
+
+	return func() tea.Msg {
+		// Clear any flash message, it belongs to the host view.
+		return errorFlashMsg{}
+	}
+}
+
+// handleJobsKey handles key presses while the Jobs view is displayed.
+func (m *Model) handleJobsKey(msg tea.KeyMsg) tea.Cmd {
+	jobs := m.jobs()
+	if m.jobCursor >= len(jobs) {
+		m.jobCursor = max(len(jobs)-1, 0)
+	}
+
+	switch {
+	case key.Matches(msg, m.keys.Up):
+		m.jobCursor = max(m.jobCursor-1, 0)
+		return nil
+
+	case key.Matches(msg, m.keys.Down):
+		m.jobCursor = min(m.jobCursor+1, max(len(jobs)-1, 0))
+		return nil
+
+	case key.Matches(msg, m.keys.Jobs), key.Matches(msg, m.keys.Quit), msg.String() == "esc":
+		m.viewMode = viewModeHosts
+		return nil
+	}
+
+	if len(jobs) == 0 {
+		return nil
+	}
+	j := jobs[m.jobCursor]
+
+	switch {
+	case key.Matches(msg, m.keys.JobOpen):
+		m.jumpToJob(j)
+		return nil
+
+	case key.Matches(msg, m.keys.JobCancel):
+		watching := j.session != nil && j.session.watch != nil
+		if watching {
+			// Also stops watching between runs.
+			stopWatch(j.session)
+		}
+		if !j.runner.Running() && !watching {
+			return func() tea.Msg {
+				return errorFlashMsg{text: fmt.Sprintf("%s of %q is not running", j.kind, j.host.name)}
+			}
+		}
+		j.runner.Cancel()
+		if j.host.reboot.tracking && j.host.reboot.session == j.session {
+			j.host.reboot.cancel()
+		}
+		return nil
+
+	case key.Matches(msg, m.keys.JobRerun):
+		m.jumpToJob(j)
+		return m.rerunJobCmd(j)
+	}
+
+	return nil
+}
+
+// jumpToJob closes the Jobs view and displays the output of j.
+func (m *Model) jumpToJob(j job) {
+	m.viewMode = viewModeHosts
+	if m.runnerInput != nil {
+		m.detachRunnerInput()
+	}
+	m.menu = nil
+
+	m.hostList.Select(j.host.name)
+	m.selectedHost = j.host
+	if j.session != nil {
+		for i, s := range j.host.runCmd.sessions {
+			if s == j.session {
+				j.host.runCmd.current = i
+			}
+		}
+	}
+	m.setVisibleHostTab(j.tab)
+}
+
+// rerunJobCmd runs the action of j again, reusing its session if idle.
+func (m *Model) rerunJobCmd(j job) tea.Cmd {
+	host := j.host
+	switch {
+	case j.tab == hostTabStatus:
+		return m.hostStatusCmd(host)
+
+	case j.tab == hostTabDeploy:
+		return m.hostDeployCmd(host)
+
+	case j.kind == actionReboot:
+		return m.confirmRebootCmd(host)
+	}
+
+	msg := j.session.msg
+	msg.session = j.session.name
+	return func() tea.Msg { return msg }
+}
+
+// jobsHint describes the keys of the Jobs view.
+func (m *Model) jobsHint() string {
+	var hints []string
+	for _, b := range []key.Binding{m.keys.JobOpen, m.keys.JobCancel, m.keys.JobRerun} {
+		hints = append(hints, b.Help().Key+": "+b.Help().Desc)
+	}
+
+	return strings.Join(append(hints, "esc: close"), " • ")
+}
+
+// renderJobs renders the Jobs view to fill the window.
+func (m *Model) renderJobs() string {
+	jobs := m.jobs()
+	width, height := m.sizes.window.width, m.sizes.window.height
+
+	header := labelStyle.Render("Jobs") + "\n\n"
+	hint := subtleStyle.Render(m.jobsHint())
+	if m.flashText != "" {
+		hint = lipgloss.NewStyle().Foreground(errorColor).Render(m.flashText)
+	}
+	if len(jobs) == 0 {
+		return header + subtleStyle.Render("Nothing has run yet") + "\n\n" + hint
+	}
+	cursor := min(m.jobCursor, len(jobs)-1)
+
+	rows := make([][]string, 0, len(jobs))
+	for _, j := range jobs {
+		rows = append(rows, []string{
+			j.host.name,
+			j.kind,
+			j.runner.StateString(),
+			jobDuration(j.runner),
+			j.runner.Destination(),
+			j.runner.String(),
+		})
+	}
+	columns := []string{"HOST", "KIND", "STATE", "TIME", "DESTINATION", "COMMAND"}
+	widths := make([]int, len(columns))
+	for _, row := range append([][]string{columns}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], lipgloss.Width(cell))
+		}
+	}
+	format := func(row []string) string {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = cell + strings.Repeat(" ", widths[i]-lipgloss.Width(cell))
+		}
+		return "  " + strings.Join(cells, "  ")
+	}
+
+	// Keep the cursor visible, leaving room for the header, column names and hint.
+	visible := max(height-lipgloss.Height(header)-3, 1)
+	offset := max(cursor-visible+1, 0)
+
+	lineStyle := lipgloss.NewStyle().MaxWidth(width)
+	var sb strings.Builder
+	sb.WriteString(header)
+	sb.WriteString(lineStyle.Render(subtleStyle.Render(format(columns))) + "\n")
+	for i := offset; i < len(rows) && i < offset+visible; i++ {
+		line := format(rows[i])
+		switch r := jobs[i].runner; {
+		case i == cursor:
+			line = lipgloss.NewStyle().Reverse(true).Render(line)
+		case r.Complete() && r.Successful():
+			line = lipgloss.NewStyle().Foreground(successColor).Render(line)
+		case r.Complete():
+			line = lipgloss.NewStyle().Foreground(failedColor).Render(line)
+		}
+		sb.WriteString(lineStyle.Render(line) + "\n")
+	}
+	sb.WriteString("\n" + hint)
+
+	return sb.String()
+}
+
+// jobDuration returns how long r has been, or was, running.
+func jobDuration(r *runner.Model) string {
+	started := r.Started()
+	if started.IsZero() {
+		return "-"
+	}
+	finished := r.Finished()
+	if finished.IsZero() {
+		finished = time.Now()
+	}
+
+	return finished.Sub(started).Round(time.Second).String()
+}
@@ -0,0 +1,61 @@
+package ui
+
+import (
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// menu is a modal list displayed in place of the content panel, ie to pick an action.
+type menu struct {
+	list     list.Model
+	hint     string                  // Displayed in the hint bar.
+	onSelect func(list.Item) tea.Cmd // Called with the chosen item once the menu has closed.
+}
+
+// openMenu displays a menu of items in the content panel.
+func (m *Model) openMenu(title string, hint string, items []list.Item, onSelect func(list.Item) tea.Cmd) {
+	l := list.New(items, list.NewDefaultDelegate(),
+		m.sizes.contentPanel.width, m.sizes.contentPanel.height)
+	l.Title = title
+	l.DisableQuitKeybindings()
+	l.SetShowHelp(false)
+	l.KeyMap.CursorUp = m.keys.Up
+	l.KeyMap.CursorDown = m.keys.Down
+	l.KeyMap.NextPage = m.keys.Right
+	l.KeyMap.PrevPage = m.keys.Left
+
+	m.menu = &menu{list: l, hint: hint, onSelect: onSelect}
+}
+
+// handleMenuKey handles key presses while a menu is open.
+func (m *Model) handleMenuKey(msg tea.KeyMsg) tea.Cmd {
+	menu := m.menu
+	if menu.list.FilterState() != list.Filtering {
+		switch msg.String() {
+		case "ctrl+c", "esc":
+			if menu.list.FilterState() == list.FilterApplied {
+				// Clear filter before closing.
+				break
+			}
+			m.menu = nil
+			return nil
+
+		case "enter":
+			m.menu = nil
+			if item := menu.list.SelectedItem(); item != nil {
+				return menu.onSelect(item)
+			}
+			return nil
+		}
+	}
+
+	var cmd tea.Cmd
+	menu.list, cmd = menu.list.Update(msg)
+	return cmd
+}
+
+// View renders the menu to fill the content panel.
+func (menu *menu) View(width, height int) string {
+	return lipgloss.NewStyle().Width(width).Height(height).Render(menu.list.View())
+}
@@ -0,0 +1,69 @@
+package ui
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/labcoat/internal/notify"
+	"github.com/jhillyerd/labcoat/internal/runner"
+)
+
+const notifyCommandTimeout = 30 * time.Second
+
+// notice tracks whether the completion of a runner has been notified, as complete runners may
+// report their final state more than once.
+type notice struct {
+	sent bool
+}
+
+// notifyCmd notifies the user that r, running action on host, has finished; once, and only if
+// it ran long enough to be worth it.
+func (m *Model) notifyCmd(host *hostModel, action string, r *runner.Model, n *notice) tea.Cmd {
+	conf := m.config.Notify
+	if n.sent || !conf.Enabled() || !slices.Contains(conf.Actions, action) {
+		return nil
+	}
+	n.sent = true
+
+	event := notify.Event{
+		Host:       host.name,
+		Action:     action,
+		Command:    r.String(),
+		State:      r.StateString(),
+		Successful: r.Successful(),
+	}
+	if started := r.Started(); !started.IsZero() {
+		event.Duration = r.Finished().Sub(started)
+	}
+	if event.Duration < conf.MinDuration.Std() {
+		return nil
+	}
+	slog.Info("Notifying job finished", "host", host.name, "action", action, "result", event.Result())
+
+	terminal, ctx := m.terminal, m.ctx
+	return func() tea.Msg {
+		if conf.Bell {
+			if err := notify.Bell(terminal); err != nil {
+				slog.Error("Failed to ring bell", "err", err)
+			}
+		}
+		if conf.Terminal != "" {
+			if err := notify.Terminal(terminal, conf.Terminal, "labcoat", event.Summary()); err != nil {
+				slog.Error("Failed to write terminal notification", "err", err)
+			}
+		}
+		if conf.Command != "" {
+			ctx, cancel := context.WithTimeout(ctx, notifyCommandTimeout)
+			defer cancel()
+			if err := notify.Run(ctx, conf.Command, event); err != nil {
+				slog.Error("Notify command failed", "host", host.name, "err", err)
+				return errorFlashMsg{text: "Notify: " + err.Error()}
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package ui
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/labcoat/internal/runner"
+)
+
+// Matches a prompt for a secret on the last line of output, ie "[sudo] password for root: ".
+var secretPromptRe = regexp.MustCompile(`(?i)(password|passphrase|passcode)[^:]*:\s*$`)
+
+// runnerInput forwards lines typed by the user to the stdin of a running runner.
+type runnerInput struct {
+	model  textinput.Model
+	runner *runner.Model
+	masked bool // Masking toggled on by user.
+}
+
+const runnerInputHelp = "enter: send line • ctrl+t: mask • ctrl+d: send EOF • esc: detach"
+
+// attachRunnerInput starts forwarding input to the visible runner.
+func (m *Model) attachRunnerInput() tea.Cmd {
+	var target *runner.Model
+	if m.selectedHost != nil {
+		m.withVisibleRunner(func(r *runner.Model) { target = r })
+	}
+	if target == nil || !target.Running() {
+		return func() tea.Msg {
+			return errorFlashMsg{text: "No running command is accepting input"}
+		}
+	}
+	if !target.InputEnabled() {
+		return func() tea.Msg {
+			return errorFlashMsg{text: fmt.Sprintf(
+				"Command was started without input, run with -i or press %s to deploy with input",
+				m.keys.DeployInput.Help().Key)}
+		}
+	}
+
+	ti := textinput.New()
+	ti.Prompt = "stdin> "
+	ti.Placeholder = runnerInputHelp
+	ti.Focus()
+
+	m.runnerInput = &runnerInput{model: ti, runner: target}
+	m.updateContentPanel()
+
+	return textinput.Blink
+}
+
+// attachStartedInput attaches input to r, which was started with input enabled, if it is visible.
+func (m *Model) attachStartedInput(r *runner.Model) tea.Cmd {
+	var visible *runner.Model
+	if m.selectedHost != nil {
+		m.withVisibleRunner(func(r *runner.Model) { visible = r })
+	}
+	if visible != r {
+		return nil
+	}
+
+	return m.attachRunnerInput()
+}
+
+// parseInputCmd splits an optional `-i` prefix from cmdline, which enables input for the command.
+func parseInputCmd(cmdline string) (bool, string) {
+	cmdline = strings.TrimSpace(cmdline)
+	rest, ok := strings.CutPrefix(cmdline, "-i ")
+	if !ok {
+		return false, cmdline
+	}
+
+	return true, strings.TrimSpace(rest)
+}
+
+// detachRunnerInput stops forwarding input, but leaves the runner stdin open.
+func (m *Model) detachRunnerInput() {
+	m.runnerInput = nil
+	m.updateContentPanel()
+}
+
+// releaseRunnerInput detaches input if it is attached to r.
+func (m *Model) releaseRunnerInput(r *runner.Model) {
+	if m.runnerInput != nil && m.runnerInput.runner == r {
+		m.detachRunnerInput()
+	}
+}
+
+func (m *Model) handleRunnerInputKey(msg tea.KeyMsg) tea.Cmd {
+	ri := m.runnerInput
+
+	switch msg.String() {
+	case "esc":
+		m.detachRunnerInput()
+		return nil
+
+	case "ctrl+c":
+		ri.runner.Cancel()
+		m.detachRunnerInput()
+		return nil
+
+	case "ctrl+d":
+		ri.runner.CloseInput()
+		m.detachRunnerInput()
+		return nil
+
+	case "ctrl+t":
+		ri.masked = !ri.masked
+		return nil
+
+	case "enter":
+		line := ri.model.Value()
+		ri.model.Reset()
+		if err := ri.runner.WriteInput(line + "\n"); err != nil {
+			slog.Error("Failed to write runner input", "err", err)
+			m.detachRunnerInput()
+			return func() tea.Msg { return errorFlashMsg{text: "Input: " + err.Error()} }
+		}
+		return nil
+	}
+
+	var cmd tea.Cmd
+	ri.model, cmd = ri.model.Update(msg)
+	return cmd
+}
+
+// View renders the input line, masked if the user requested it or the runner appears to be
+// prompting for a secret.
+func (ri runnerInput) View() string {
+	ti := ri.model
+	if ri.masked || promptsForSecret(ri.runner.View()) {
+		ti.EchoMode = textinput.EchoPassword
+		ti.Prompt = "stdin (masked)> "
+	}
+
+	return ti.View()
+}
+
+func promptsForSecret(output string) bool {
+	output = strings.TrimRight(output, " ")
+	if i := strings.LastIndexByte(output, '\n'); i != -1 {
+		output = output[i+1:]
+	}
+
+	return secretPromptRe.MatchString(output)
+}
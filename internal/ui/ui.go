@@ -3,10 +3,12 @@ package ui
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"math"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,71 +21,121 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/jhillyerd/labcoat/internal/config"
+	"github.com/jhillyerd/labcoat/internal/health"
+	"github.com/jhillyerd/labcoat/internal/history"
 	"github.com/jhillyerd/labcoat/internal/nix"
 	"github.com/jhillyerd/labcoat/internal/npool"
+	"github.com/jhillyerd/labcoat/internal/reboot"
 	"github.com/jhillyerd/labcoat/internal/runner"
+	"github.com/jhillyerd/labcoat/internal/sshdest"
+	"github.com/jhillyerd/labcoat/internal/transport"
 )
 
 const (
 	viewModeHosts = iota
 	viewModeText
 	viewModeError
+	viewModeJobs
 )
 
 const (
 	hostTabStatus = iota
 	hostTabDeploy
 	hostTabRunCmd
+	hostTabHistory
 )
 
-var hostTabNames = []string{"Host Status", "Deploy", "Run Command"}
+var hostTabNames = []string{"Host Status", "Deploy", "Run Command", "History"}
 
 type Model struct {
-	ctx          context.Context
-	program      *tea.Program
-	config       config.Config
-	ready        bool // true once screen size is known.
-	viewMode     int  // Current UI mode.
-	flakePath    string
-	hostList     hostListModel
-	hosts        map[string]*hostModel
-	selectedHost *hostModel
-	hoverTimer   *time.Timer // Triggers host status collection when user hovers.
-	nixPool      *npool.Pool
-	contentPanel *viewport.Model
-	sizes        layoutSizes
-	keys         config.KeyMap
-	help         help.Model
-	spinner      spinner.Model
-	jumpToLetter bool
-	confirmation *confirmationMsg
-	textInput    *textInput
-	text         string
-	error        string
-	flashText    string
-	flashTimer   *time.Timer
+	ctx           context.Context
+	program       *tea.Program
+	config        config.Config
+	ready         bool // true once screen size is known.
+	viewMode      int  // Current UI mode.
+	flakePath     string
+	hostList      hostListModel
+	hosts         map[string]*hostModel
+	selectedHost  *hostModel
+	hover         *hover // Work triggered by hovering over the selected host, nil if none.
+	nixPool       *npool.Pool
+	healthPool    *npool.Pool // Limits concurrent health checks.
+	healthChecker *health.Checker
+	focused       bool                     // Terminal has focus, or does not report it.
+	lastActivity  time.Time                // Of the most recent key press.
+	sshPool       *transport.SSHPool       // Connections for the ssh-native transport.
+	sshMaster     *transport.ControlMaster // Shared connections for the ssh transport, may be nil.
+	knownHosts    *transport.KnownHosts    // Host keys pinned from the flake, may be nil.
+	history       *history.Store           // Records completed runs, nil if disabled.
+	terminal      io.Writer                // Receives bell & notification escape sequences.
+	contentPanel  *viewport.Model
+	sizes         layoutSizes
+	keys          config.KeyMap
+	help          help.Model
+	spinner       spinner.Model
+	jumpToLetter  bool
+	confirmation  *confirmationMsg
+	textInput     *textInput
+	menu          *menu        // Modal menu displayed over the content panel.
+	jobCursor     int          // Selected row of the Jobs view.
+	runnerInput   *runnerInput // Forwards key presses to visible runner stdin when attached.
+	text          string
+	error         string
+	flashText     string
+	flashTimer    *time.Timer
 }
 
 type hostModel struct {
-	name    string
-	target  *nix.TargetInfo // Cached info about target host.
-	hostTab int             // Currently visible host tab.
+	name          string
+	target        *nix.TargetInfo     // Cached info about target host.
+	targetPending bool                // Target info is being queried.
+	queue         []*queuedAction     // Actions waiting for the host to become ready.
+	transport     transport.Transport // Runs commands on target host, available with target.
+	health        struct {
+		result   health.Result // Most recent check.
+		checking bool
+	}
+	hostTab int          // Currently visible host tab.
+	sudo    *runner.Sudo // Sudo authentication, nil until required.
 	deploy  struct {
 		intro        string // Rendered intro text: command, host, etc.
 		contentPanel viewport.Model
 		runner       *runner.Model
 		cancel       func()
+		preflighting bool   // Preflight checks are running.
+		preflight    string // Rendered preflight check results.
+		history      historyRun
+		notice       notice
 	}
 	runCmd struct {
-		intro        string // Rendered intro text: command, host, etc.
+		sessions []*runSession // Never empty.
+		current  int           // Index of the visible session.
+	}
+	history struct {
+		loaded       bool             // Records have been read from the store.
+		records      []history.Record // Most recent first.
+		err          error            // Failed to read records.
+		selected     *history.Record  // Displayed run, nil to list runs.
+		output       string           // Output of the selected run.
 		contentPanel viewport.Model
-		runner       *runner.Model
+	}
+	reboot struct {
+		tracking bool            // Waiting for the host to come back up.
+		cancel   func()          // Stops tracking.
+		statuses []reboot.Status // Phases reached so far.
+		err      error           // Tracking failed.
+		session  *runSession     // Displays the reboot, nil if closed.
 	}
 	status struct {
 		collected    bool   // Whether status has been collected for this host.
 		intro        string // Rendered intro text: command, host, etc.
 		contentPanel viewport.Model
 		runner       *runner.Model
+		parser       *runner.Parser // Parses status runner output.
+		parsed       int64          // Runner output bytes written to parser.
+		refreshing   bool           // Automatic refresh, previous status displayed until complete.
+		notice       notice
+		staleKeys    bool // User known_hosts does not match the flake, not yet offered to replace.
 	}
 }
 
@@ -93,6 +145,7 @@ type textInput struct {
 }
 
 type layoutSizes struct {
+	window        dim
 	hostList      dim
 	contentHeader dim
 	contentPanel  dim
@@ -105,7 +158,23 @@ type dim struct {
 }
 
 func New(conf config.Config, keys config.KeyMap, flakePath string, hostNames []string) Model {
-	hostList := newHostList(hostNames)
+	hosts := make(map[string]*hostModel, len(hostNames))
+	var dot func(name string) string
+	if conf.Health.Interval > 0 {
+		dot = func(name string) string {
+			if host := hosts[name]; host != nil {
+				return healthDot(host)
+			}
+			return " "
+		}
+	}
+	hostList := newHostList(hostNames, dot, func(name string) string {
+		host := hosts[name]
+		if host == nil {
+			return ""
+		}
+		return strings.TrimSpace(rebootIndicator(host) + " " + queueIndicator(host))
+	})
 	hostList.list.KeyMap.CursorUp = keys.Up
 	hostList.list.KeyMap.CursorDown = keys.Down
 	hostList.list.KeyMap.Filter = keys.Filter
@@ -116,25 +185,87 @@ func New(conf config.Config, keys config.KeyMap, flakePath string, hostNames []s
 	spin.Spinner = spinner.MiniDot
 	spin.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("#80c080"))
 
-	hosts := make(map[string]*hostModel, len(hostNames))
 	for _, v := range hostNames {
 		hm := &hostModel{name: v}
 		hm.status.contentPanel = newContentPanel(keys)
-		hm.runCmd.contentPanel = newContentPanel(keys)
+		hm.runCmd.sessions = []*runSession{{contentPanel: newContentPanel(keys)}}
+		hm.history.contentPanel = newContentPanel(keys)
 		hosts[v] = hm
 	}
 
+	var sshMaster *transport.ControlMaster
+	if conf.Hosts.SSHControlMaster {
+		var err error
+		if sshMaster, err = transport.NewControlMaster(); err != nil {
+			slog.Error("SSH ControlMaster disabled", "err", err)
+		}
+	}
+
+	var knownHosts *transport.KnownHosts
+	if conf.Hosts.HostKeysAttr != "" {
+		var err error
+		if knownHosts, err = transport.NewKnownHosts(); err != nil {
+			slog.Error("Host key pinning disabled", "err", err)
+		}
+	}
+	sshPool := transport.NewSSHPool()
+	sshPool.KnownHosts = knownHosts
+
+	var historyStore *history.Store
+	if conf.General.History {
+		dir := conf.General.HistoryDir
+		if dir == "" {
+			var err error
+			if dir, err = history.DefaultDir(); err != nil {
+				slog.Error("History disabled", "err", err)
+			}
+		}
+		if dir != "" {
+			historyStore = history.NewStore(dir, conf.General.HistoryLimit)
+		}
+	}
+
+	checker := health.NewChecker(conf.Health.Method)
+	checker.Timeout = conf.Health.Timeout.Std()
+
 	return Model{
-		ctx:       context.Background(),
-		config:    conf,
-		viewMode:  viewModeHosts,
-		flakePath: flakePath,
-		hostList:  hostList,
-		hosts:     hosts,
-		nixPool:   npool.New("nix", 2),
-		keys:      keys,
-		help:      help.New(),
-		spinner:   spin,
+		ctx:           context.Background(),
+		config:        conf,
+		viewMode:      viewModeHosts,
+		flakePath:     flakePath,
+		hostList:      hostList,
+		hosts:         hosts,
+		nixPool:       npool.New("nix", 2),
+		healthPool:    npool.New("health", conf.Health.Concurrency),
+		healthChecker: checker,
+		focused:       true,
+		lastActivity:  time.Now(),
+		sshPool:       sshPool,
+		sshMaster:     sshMaster,
+		knownHosts:    knownHosts,
+		history:       historyStore,
+		terminal:      os.Stdout,
+		keys:          keys,
+		help:          help.New(),
+		spinner:       spin,
+	}
+}
+
+// Close releases resources held by the UI, such as pooled SSH connections.
+func (m Model) Close() {
+	m.stopHealth()
+	if err := m.sshPool.Close(); err != nil {
+		slog.Warn("Failed to close SSH connections", "err", err)
+	}
+	if m.sshMaster != nil {
+		if err := m.sshMaster.Close(); err != nil {
+			slog.Warn("Failed to close SSH ControlMaster", "err", err)
+		}
+	}
+	if m.knownHosts != nil {
+		if err := m.knownHosts.Close(); err != nil {
+			slog.Warn("Failed to remove pinned known_hosts", "err", err)
+		}
 	}
 }
 
@@ -155,8 +286,11 @@ func newContentPanel(keys config.KeyMap) viewport.Model {
 }
 
 type hostTargetInfoMsg struct {
-	hostName string
-	target   nix.TargetInfo
+	hostName   string
+	target     nix.TargetInfo
+	err        error
+	hover      *hover // Set if requested by hovering.
+	background bool   // Requested by health polling, rather than the user.
 }
 
 type hostChangedMsg struct {
@@ -165,6 +299,7 @@ type hostChangedMsg struct {
 
 type hostHoverMsg struct {
 	hostName string
+	hover    *hover
 }
 
 type openPagerMsg struct{}
@@ -177,6 +312,7 @@ type confirmationMsg struct {
 
 type textInputPromptMsg struct {
 	prompt   string
+	masked   bool // Hide input, ie for passwords.
 	submitFn func(string) tea.Cmd
 }
 
@@ -189,10 +325,15 @@ type errorFlashMsg struct {
 }
 
 func (m Model) Init() tea.Cmd {
-	return tea.Batch(
-		m.hostList.Init(),
-		m.spinner.Tick,
-	)
+	cmds := []tea.Cmd{m.hostList.Init(), m.spinner.Tick}
+	if m.config.Commands.StatusRefresh > 0 {
+		cmds = append(cmds, statusRefreshTickCmd())
+	}
+	if m.healthEnabled() {
+		cmds = append(cmds, m.startHealthCmd())
+	}
+
+	return tea.Batch(cmds...)
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -201,9 +342,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		cmds []tea.Cmd
 	)
 
+	if focused, ok := focusEvent(msg); ok {
+		return m, m.handleFocusMsg(focused)
+	}
+
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		// slog.Debug("tea.KeyMsg", "key", msg)
+		m.lastActivity = time.Now()
 
 		if msg.String() == "ctrl+\\" {
 			// Ctrl-\ overrides all view states to exit.
@@ -228,6 +374,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 
+		if m.viewMode == viewModeJobs {
+			return m, m.handleJobsKey(msg)
+		}
+
 		if m.hostList.FilterState() == list.Filtering {
 			// User is entering filter text, disable keymaps.
 			break
@@ -288,14 +438,34 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, cmd
 		}
 
+		if m.menu != nil {
+			// Menu is capturing key presses.
+			return m, m.handleMenuKey(msg)
+		}
+
+		if m.runnerInput != nil {
+			// Input is attached to a runner, capturing key presses.
+			return m, m.handleRunnerInputKey(msg)
+		}
+
 		if msg.String() == "ctrl+c" {
 			m.withVisibleRunner(func(r *runner.Model) {
 				r.Cancel()
 			})
+			if h := m.selectedHost; h != nil && h.hostTab == hostTabRunCmd {
+				stopWatch(h.currentRunSession())
+				if h.reboot.tracking && h.reboot.session == h.currentRunSession() {
+					h.reboot.cancel()
+				}
+			}
 
 			return m, nil
 		}
 
+		if i := matchAction(msg, m.keys.Actions); i >= 0 {
+			return m, m.hostActionCmd(m.selectedHost, m.config.Commands.Actions[i])
+		}
+
 		switch {
 		case key.Matches(msg, m.keys.Jump):
 			m.jumpToLetter = true
@@ -307,32 +477,71 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, m.keys.NextTab):
 			return m, m.handleNextTabKey()
 
+		case key.Matches(msg, m.keys.ActionMenu):
+			return m, m.openActionMenu(m.selectedHost)
+
+		case key.Matches(msg, m.keys.NewSession):
+			return m, m.newRunSessionCmd(m.selectedHost)
+
+		case key.Matches(msg, m.keys.NextSession):
+			m.switchRunSession(m.selectedHost, 1)
+			return m, nil
+
+		case key.Matches(msg, m.keys.PrevSession):
+			m.switchRunSession(m.selectedHost, -1)
+			return m, nil
+
+		case key.Matches(msg, m.keys.CloseSession):
+			return m, m.closeRunSessionCmd(m.selectedHost)
+
+		case key.Matches(msg, m.keys.CancelQueued):
+			return m, m.cancelQueuedCmd(m.selectedHost)
+
+		case key.Matches(msg, m.keys.OpenHistory):
+			return m, m.openHistoryMenu(m.selectedHost)
+
+		case key.Matches(msg, m.keys.Jobs):
+			return m, m.openJobs()
+
+		case key.Matches(msg, m.keys.AttachInput):
+			return m, m.attachRunnerInput()
+
 		case key.Matches(msg, m.keys.Deploy):
 			return m, m.hostDeployCmd(m.selectedHost)
 
+		case key.Matches(msg, m.keys.DeployInput):
+			host := m.selectedHost
+			return m, func() tea.Msg { return hostDeployMsg{host: host, input: true} }
+
 		case key.Matches(msg, m.keys.Pager):
 			return m, func() tea.Msg { return openPagerMsg{} }
 
 		case key.Matches(msg, m.keys.Reboot):
-			if ok, cmd := requireHostTarget("Reboot", m.selectedHost); !ok {
-				return m, cmd
-			}
-			return m, func() tea.Msg {
-				return confirmationMsg{
-					text:   fmt.Sprintf("Confirm reboot of %q? y/n:", m.selectedHost.target.DeployHost),
-					yesCmd: m.hostRunCommandCmd(m.selectedHost, "/run/current-system/sw/bin/reboot"),
-				}
-			}
+			return m, m.confirmRebootCmd(m.selectedHost)
 
 		case key.Matches(msg, m.keys.RunCommandPrompt):
-			if ok, cmd := requireHostTarget("RunCommand", m.selectedHost); !ok {
-				return m, cmd
+			host := m.selectedHost
+			if host == nil {
+				return m, nil
+			}
+			// Queued until target info is available.
+			prompt := fmt.Sprintf("Run on %q (queued), -i for input: ", host.name)
+			if host.target != nil {
+				prompt = fmt.Sprintf("Run on %q, -i for input: ", host.target.DeployHost)
 			}
 			return m, func() tea.Msg {
 				return textInputPromptMsg{
-					prompt: fmt.Sprintf("Run on %q: ", m.selectedHost.target.DeployHost),
-					submitFn: func(cmd string) tea.Cmd {
-						return m.hostRunCommandCmd(m.selectedHost, cmd)
+					prompt: prompt,
+					submitFn: func(cmdline string) tea.Cmd {
+						input, cmdline := parseInputCmd(cmdline)
+						return func() tea.Msg {
+							return hostRunCommandMsg{
+								host:   host,
+								action: actionRunCommand,
+								prog:   cmdline,
+								input:  input,
+							}
+						}
 					},
 				}
 			}
@@ -340,6 +549,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, m.keys.Status):
 			return m, m.hostStatusCmd(m.selectedHost)
 
+		case key.Matches(msg, m.keys.Watch):
+			return m, m.watchPromptCmd(m.selectedHost)
+
 		case key.Matches(msg, m.keys.SSHInto):
 			return m, m.startHostInteractiveSSH()
 
@@ -372,12 +584,57 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case hostDeployOutputMsg:
 		return m, m.handleHostDeployOutputMsg(msg)
 
+	case hostPreflightMsg:
+		return m, m.handleHostPreflightMsg(msg)
+
+	case hostSudoCheckedMsg:
+		return m, m.handleHostSudoCheckedMsg(msg)
+
+	case hostStatusRequestMsg:
+		return m, m.startHostStatus(msg.host, !msg.background)
+
+	case statusRefreshMsg:
+		return m, m.handleStatusRefreshMsg(msg)
+
+	case healthTickMsg:
+		return m, m.handleHealthTickMsg(msg)
+
+	case hostHealthMsg:
+		return m, m.handleHostHealthMsg(msg)
+
 	case hostRunCommandMsg:
 		return m, m.handleHostRunCommandMsg(msg)
 
 	case hostRunCommandOutputMsg:
 		return m, m.handleHostRunCommandOutputMsg(msg)
 
+	case hostRebootRequestMsg:
+		return m, m.confirmRebootCmd(msg.host)
+
+	case hostRebootMsg:
+		return m, m.handleHostRebootMsg(msg)
+
+	case hostRebootStatusMsg:
+		return m, m.handleHostRebootStatusMsg(msg)
+
+	case hostActionRequestMsg:
+		return m, m.hostActionCmd(msg.host, msg.action)
+
+	case actionMenuRequestMsg:
+		return m, m.openActionMenu(msg.host)
+
+	case newSessionRequestMsg:
+		return m, m.newRunSessionCmd(msg.host)
+
+	case runSessionCloseMsg:
+		return m, m.handleRunSessionCloseMsg(msg)
+
+	case runSessionWatchMsg:
+		return m, m.handleRunSessionWatchMsg(msg)
+
+	case historyRecordedMsg:
+		return m, m.handleHistoryRecordedMsg(msg)
+
 	case openPagerMsg:
 		return m, m.handleOpenPagerMsg(msg)
 
@@ -385,6 +642,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.sizes = calculateSizes(msg)
 		m.hostList.SetSize(m.sizes.hostList.width, m.sizes.hostList.height)
 		m.updateContentPanel()
+		if m.menu != nil {
+			m.menu.list.SetSize(m.sizes.contentPanel.width, m.sizes.contentPanel.height)
+		}
 
 		return m, nil
 
@@ -395,6 +655,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case textInputPromptMsg:
 		ti := textinput.New()
 		ti.Prompt = msg.prompt
+		if msg.masked {
+			ti.EchoMode = textinput.EchoPassword
+		}
 		ti.Focus()
 		m.textInput = &textInput{
 			model:    ti,
@@ -456,7 +719,10 @@ func (m *Model) updateContentPanel() {
 		case hostTabDeploy:
 			m.contentPanel = &m.selectedHost.deploy.contentPanel
 		case hostTabRunCmd:
-			m.contentPanel = &m.selectedHost.runCmd.contentPanel
+			m.contentPanel = &m.selectedHost.currentRunSession().contentPanel
+		case hostTabHistory:
+			m.contentPanel = &m.selectedHost.history.contentPanel
+			m.loadHistory(m.selectedHost)
 		default:
 			slog.Error("Unknown host tab index (bug)", "index", m.selectedHost.hostTab)
 			return
@@ -464,107 +730,191 @@ func (m *Model) updateContentPanel() {
 
 		m.contentPanel.Width = m.sizes.contentPanel.width
 		m.contentPanel.Height = m.sizes.contentPanel.height
+		if m.runnerInput != nil {
+			// Make room for input line.
+			m.contentPanel.Height--
+		}
 		m.ready = true
 	}
 }
 
-func (m *Model) handleHostChangedMsg(msg hostChangedMsg) tea.Cmd {
-	// slog.Debug("hostChanged", "host", msg.hostName)
-
-	m.selectedHost = m.hosts[msg.hostName]
-	m.updateContentPanel()
-
-	if m.hoverTimer != nil {
-		// Discard timer for previous host.
-		m.hoverTimer.Stop()
-		m.hoverTimer = nil
-	}
-
-	// Trigger fetch status after timeout.
-	m.hoverTimer = time.NewTimer(500 * time.Millisecond)
-	return func() tea.Msg {
-		<-m.hoverTimer.C
-		return hostHoverMsg{hostName: msg.hostName}
-	}
-}
-
-func (m *Model) handleHostHoverMsg(msg hostHoverMsg) tea.Cmd {
-	hostName := msg.hostName
-	host := m.hosts[hostName]
-
-	if host.target == nil {
-		// Must collect target info before querying host status.
-		return m.hostTargetInfoCmd(host)
+// hostTargetInfoCmd fetches target info for host, cancelled with hv if it is not nil.
+func (m *Model) hostTargetInfoCmd(host *hostModel, hv *hover) tea.Cmd {
+	// Init status display.
+	intro := lipgloss.NewStyle().
+		Foreground(subtleColor).
+		Render("Querying nix for information on "+host.name) + "\n"
+	host.status.contentPanel.SetContent(intro)
+	if host == m.selectedHost {
+		m.setVisibleHostTab(hostTabStatus)
+		m.updateContentPanel()
 	}
 
-	if host.status.collected {
-		// Only collect status on hover once.
-		return nil
+	parent := m.ctx
+	if hv != nil {
+		parent = hv.ctx
 	}
 
-	return m.hostStatusCmd(host)
+	return m.fetchTargetInfoCmd(host, parent, hv, false)
 }
 
-func (m *Model) hostTargetInfoCmd(host *hostModel) tea.Cmd {
-	// Init status display.
-	intro := lipgloss.NewStyle().
-		Foreground(subtleColor).
-		Render("Querying nix for information on "+host.name) + "\n"
-	host.status.contentPanel.SetContent(intro)
-	m.setVisibleHostTab(hostTabStatus)
-	m.updateContentPanel()
+// fetchTargetInfoCmd evaluates the target info of host with a nix worker, until parent is done.
+func (m *Model) fetchTargetInfoCmd(
+	host *hostModel, parent context.Context, hv *hover, background bool,
+) tea.Cmd {
+	host.targetPending = true
 
 	return func() tea.Msg {
 		const getNixWorkerTimeout = 30 * time.Second
 
-		ctx, done := context.WithTimeout(context.Background(), getNixWorkerTimeout)
+		ctx, done := context.WithTimeout(parent, getNixWorkerTimeout)
 		defer done()
 
 		worker, err := m.nixPool.Get(ctx)
 		if err != nil {
-			slog.Error("failed to get nix worker", "err", err, "timeout", getNixWorkerTimeout)
-			return nil
+			if parent.Err() == nil {
+				slog.Error("failed to get nix worker", "err", err, "timeout", getNixWorkerTimeout)
+			}
+			return hostTargetInfoMsg{
+				hostName:   host.name,
+				err:        fmt.Errorf("nix worker unavailable: %w", err),
+				hover:      hv,
+				background: background,
+			}
 		}
 		defer worker.Done()
 
 		slog.Info("Fetching target info from nix", "host", host.name, "worker", worker)
-		targetInfo, nerr := nix.GetTargetInfo(nix.TargetInfoRequest{
+		targetInfo, nerr := nix.GetTargetInfo(parent, nix.TargetInfoRequest{
 			FlakePath: m.flakePath,
 			HostName:  host.name,
 			Config:    m.config,
 		})
 		if nerr != nil {
-			slog.Error("Failed to fetch target info from nix",
-				"host", host.name, "worker", worker, "err", nerr)
-			return criticalErrorMsg{detail: nerr.Error()}
+			if parent.Err() == nil {
+				slog.Error("Failed to fetch target info from nix",
+					"host", host.name, "worker", worker, "err", nerr)
+			}
+			return hostTargetInfoMsg{hostName: host.name, err: nerr, hover: hv, background: background}
 		}
 		slog.Debug("Got target info", "host", host.name, "worker", worker, "info", targetInfo)
 
-		return hostTargetInfoMsg{hostName: host.name, target: *targetInfo}
+		return hostTargetInfoMsg{
+			hostName: host.name, target: *targetInfo, hover: hv, background: background,
+		}
 	}
 }
 
 func (m *Model) handleHostTargetInfoMsg(msg hostTargetInfoMsg) tea.Cmd {
-	// Store target info in hostModel.
 	host := m.hosts[msg.hostName]
-	host.target = &msg.target
+	host.targetPending = false
+	if msg.background {
+		host.health.checking = false
+	}
+	if msg.err != nil && msg.hover != nil && msg.hover.ctx.Err() != nil {
+		// Selection moved away before target info was available.
+		slog.Debug("Cancelled hover target info", "host", host.name)
+		host.status.contentPanel.SetContent(subtleStyle.Render("Query cancelled, select host to retry"))
+		if len(host.queue) > 0 {
+			// Queued actions still require target info.
+			return m.hostTargetInfoCmd(host, nil)
+		}
+		return nil
+	}
+	if msg.err != nil && msg.background && len(host.queue) == 0 {
+		return m.handleHealthTargetInfoErr(host, msg.err)
+	}
+	if msg.err != nil {
+		if len(host.queue) > 0 {
+			slog.Warn("Dropped queued actions", "host", host.name, "count", len(host.queue))
+			host.queue = nil
+		}
+		return func() tea.Msg { return criticalErrorMsg{detail: msg.err.Error()} }
+	}
+	target := &msg.target
+
+	// Deploy host may include a user and port, ie `root@[2001:db8::1]:2222`.
+	dest, err := sshdest.Parse(target.DeployHost)
+	if err != nil {
+		slog.Error("Invalid deploy host", "host", host.name, "err", err)
+		return func() tea.Msg { return criticalErrorMsg{detail: err.Error()} }
+	}
 
 	// Apply defaults.
 	if m.config.Hosts.DefaultSSHDomain != "" &&
-		strings.IndexRune(host.target.DeployHost, '.') == -1 {
+		strings.IndexRune(dest.Host, '.') == -1 && !dest.IsIP() {
 		// Append default domain.
-		host.target.DeployHost += "." + m.config.Hosts.DefaultSSHDomain
+		dest.Host += "." + m.config.Hosts.DefaultSSHDomain
+	}
+	if target.DeployUser == "" {
+		target.DeployUser = dest.User
+	}
+	if target.DeployUser == "" {
+		target.DeployUser = m.config.Hosts.DefaultSSHUser
+	}
+	dest.User = target.DeployUser
+	target.DeployHost = dest.Host
+	target.Destination = dest
+	if target.Transport == "" {
+		target.Transport = m.config.Hosts.DefaultTransport
+	}
+	if target.Shell == "" {
+		target.Shell = m.config.Hosts.DefaultShell
+	}
+	switch target.JumpHost {
+	case "":
+		target.JumpHost = m.config.Hosts.DefaultJumpHost
+	case "none":
+		target.JumpHost = ""
+	}
 
+	// Must be pinned before the transport is constructed.  Only the user's own fetches may prompt.
+	pinCmd := m.pinHostKeys(host, target, !msg.background && host == m.selectedHost)
+
+	t, err := m.newTransport(target)
+	if err != nil {
+		slog.Error("Failed to construct transport", "host", host.name, "err", err)
+		return func() tea.Msg { return criticalErrorMsg{detail: err.Error()} }
 	}
-	if host.target.DeployUser == "" {
-		host.target.DeployUser = m.config.Hosts.DefaultSSHUser
+
+	// Store target info in hostModel.
+	host.target = target
+	host.transport = t
+
+	// Fetch host status now that we know target info, unless the user has queued it or hover is
+	// configured not to.
+	statusQueued := host.queued(actionStatus)
+	cmds := []tea.Cmd{pinCmd, m.runQueueCmd(host)}
+	hv := msg.hover
+	if msg.background {
+		cmds = append(cmds, m.hostHealthCmd(host))
+
+		// User may have hovered over the host while it was fetched.
+		hv = nil
+		if m.hover != nil && m.hover.host == host {
+			hv = m.hover
+		} else if len(host.queue) == 0 {
+			return tea.Batch(cmds...)
+		}
+	}
+	switch {
+	case statusQueued || !m.hoverCollectsStatus(hv):
+		// Not collected now.
+	case hv != nil:
+		cmds = append(cmds, m.hoverStatusCmd(hv, host))
+	default:
+		cmds = append(cmds, m.hostStatusCmd(host))
 	}
 
-	// Fetch host status now that we know target info.
-	return m.hostStatusCmd(host)
+	return tea.Batch(cmds...)
 }
 
 func (m *Model) handleOpenPagerMsg(_ openPagerMsg) tea.Cmd {
+	if h := m.selectedHost; h != nil && h.hostTab == hostTabHistory && h.history.selected != nil {
+		// Recorded output is already in a file.
+		return m.pagerCmd(m.history.OutputPath(*h.history.selected), false)
+	}
+
 	// Write visible runner buffer to temp file.
 	f, err := os.CreateTemp("", "*.txt")
 	if err != nil {
@@ -592,10 +942,19 @@ func (m *Model) handleOpenPagerMsg(_ openPagerMsg) tea.Cmd {
 		return func() tea.Msg { return errorFlashMsg{text: "Pager: " + err.Error()} }
 	}
 
+	return m.pagerCmd(fname, true)
+}
+
+// pagerCmd displays the file fname in the pager, removing it afterwards if temp is true.
+func (m *Model) pagerCmd(fname string, temp bool) tea.Cmd {
 	// TODO handle pager arguments.
 	cmd := exec.Command(m.config.General.Pager, fname)
-	return tea.ExecProcess(cmd, func(err error) tea.Msg {
-		defer os.Remove(fname)
+	resume := m.resumeFocusReporting
+	return tea.Sequence(m.pauseFocusReportingCmd(), tea.ExecProcess(cmd, func(err error) tea.Msg {
+		defer resume()
+		if temp {
+			defer os.Remove(fname)
+		}
 
 		if err != nil {
 			slog.Error("Pager failed", "err", err)
@@ -603,7 +962,7 @@ func (m *Model) handleOpenPagerMsg(_ openPagerMsg) tea.Cmd {
 		}
 
 		return nil
-	})
+	}))
 }
 
 func (m *Model) handleErrorFlashMsg(msg errorFlashMsg) tea.Cmd {
@@ -634,11 +993,12 @@ func (m *Model) startHostInteractiveSSH() tea.Cmd {
 	slog.Info("starting interactive SSH", "host", host.name)
 
 	// TODO look into tea.ExecCommand interface to display destination host to user, handle errors.
-	dest := host.target.SSHDestination()
-	cmd := exec.Command("ssh", dest)
+	cmd := host.transport.Interactive()
 	prog := m.program
+	resume := m.resumeFocusReporting
 
-	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+	return tea.Sequence(m.pauseFocusReportingCmd(), tea.Exec(cmd, func(err error) tea.Msg {
+		defer resume()
 		if err != nil {
 			prog.ReleaseTerminal()
 			defer prog.RestoreTerminal()
@@ -650,7 +1010,7 @@ func (m *Model) startHostInteractiveSSH() tea.Cmd {
 		}
 
 		return nil
-	})
+	}))
 }
 
 var (
@@ -659,10 +1019,14 @@ var (
 	confirmColor = lipgloss.Color("220")
 	labelFgColor = lipgloss.Color("230")
 	labelBgColor = lipgloss.Color("62")
+	successColor = lipgloss.Color("28")
+	failedColor  = lipgloss.Color("124")
 
 	subtleStyle = lipgloss.NewStyle().Foreground(subtleColor)
 	labelStyle  = lipgloss.NewStyle().MarginTop(1).Padding(0, 1).
 			Foreground(labelFgColor).Background(labelBgColor)
+	labelSuccessStyle  = labelStyle.Background(successColor)
+	labelFailedStyle   = labelStyle.Background(failedColor)
 	hostListStyle      = lipgloss.NewStyle().Border(lipgloss.NormalBorder(), true).Padding(0, 1)
 	tabSuffixStyle     = lipgloss.NewStyle().Border(tabSuffixBorder(), true).Padding(0, 1)
 	contentFooterStyle = lipgloss.NewStyle().Reverse(true).Padding(0, 1)
@@ -703,9 +1067,29 @@ func (m Model) View() string {
 
 			m.withVisibleRunner(func(r *runner.Model) {
 				if r.Running() {
-					scroll += " - Running"
+					// Includes the cancellation stage, if any.
+					scroll += " - " + r.StateString()
 				}
 			})
+			switch selectedTab {
+			case hostTabStatus:
+				if refresh := m.config.Commands.StatusRefresh; refresh > 0 {
+					scroll += " - refresh every " + refresh.Std().String()
+				}
+			case hostTabRunCmd:
+				if sessions := renderRunSessions(m.selectedHost); sessions != "" {
+					scroll = sessions + "  " + scroll
+				}
+				if w := m.selectedHost.currentRunSession().watch; w != nil {
+					scroll += " - every " + w.interval.String()
+				}
+			}
+			if r := m.selectedHost.health.result; r.Err != nil && selectedTab == hostTabStatus {
+				scroll += " - " + r.State.String()
+			}
+			if queue := renderQueue(m.selectedHost); queue != "" {
+				scroll += " - " + queue
+			}
 		}
 
 		var renderedTabs []string
@@ -736,8 +1120,15 @@ func (m Model) View() string {
 		contentHeader := lipgloss.JoinHorizontal(lipgloss.Top, renderedTabs...)
 
 		contentFooter := contentFooterStyle.Render(scroll)
+		if m.runnerInput != nil {
+			contentFooter += "\n" + m.runnerInput.View()
+		}
+		panel := m.contentPanel.View()
+		if m.menu != nil {
+			panel = m.menu.View(m.sizes.contentPanel.width, m.sizes.contentPanel.height)
+		}
 		content := contentHeader + "\n" +
-			contentPanelStyle.Render(m.contentPanel.View()+"\n"+contentFooter)
+			contentPanelStyle.Render(panel+"\n"+contentFooter)
 
 		// Display help or error flash if present.
 		hintBar := ""
@@ -754,6 +1145,9 @@ func (m Model) View() string {
 		case m.textInput != nil:
 			hintBar = m.textInput.model.View()
 
+		case m.menu != nil:
+			hintBar = confirmDialogStyle.Render(m.menu.hint)
+
 		default:
 			hintBar = hintBarStyle.Render(m.help.ShortHelpView(m.keys.ShortHelp()))
 		}
@@ -764,6 +1158,9 @@ func (m Model) View() string {
 		return m.text + "\n\n" +
 			subtleStyle.Render("[Press any key to continue]")
 
+	case viewModeJobs:
+		return m.renderJobs()
+
 	case viewModeError:
 		return labelStyle.Render("Critical Error") +
 			"\n\n" +
@@ -785,6 +1182,8 @@ func calculateSizes(win tea.WindowSizeMsg) layoutSizes {
 		frameHeight int
 	)
 
+	s.window = dim{width: win.Width, height: win.Height}
+
 	// Host list and hint bar.
 	s.hintBar.height = 1
 	hintBarHeight := s.hintBar.height + hintBarStyle.GetVerticalFrameSize()
@@ -827,6 +1226,79 @@ func requireHostTarget(logName string, host *hostModel) (bool, tea.Cmd) {
 	return true, nil
 }
 
+// newLocalRunner constructs a runner for a local command in the flake directory, with the
+// configured environment.
+func (m *Model) newLocalRunner(
+	ctx context.Context, onUpdate func(*runner.Model) tea.Msg, prog string, args ...string,
+) *runner.Model {
+	r := runner.NewLocal(ctx, onUpdate, m.flakePath, prog, args...)
+	for _, name := range m.config.Env.Pass {
+		r.PassEnv(name)
+	}
+
+	// Sorted for a stable environment display.
+	extra := m.config.Env.Extra
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.SetEnv(name, extra[name])
+	}
+
+	return r
+}
+
+// sshOptions returns the OpenSSH options for target, excluding connection sharing.
+func (m *Model) sshOptions(target *nix.TargetInfo) []string {
+	var opts []string
+	if m.knownHosts != nil {
+		opts = append(opts, m.knownHosts.Options(target.DeployHost)...)
+	}
+	if target.JumpHost != "" {
+		opts = append(opts, "-oProxyJump="+target.JumpHost)
+	}
+
+	return opts
+}
+
+// newTransport constructs the transport used to run commands on a target host.
+func (m *Model) newTransport(target *nix.TargetInfo) (transport.Transport, error) {
+	switch target.Transport {
+	case "ssh":
+		t := &transport.OpenSSH{
+			User: target.DeployUser,
+			Host: target.DeployHost,
+			Port: target.Destination.Port,
+		}
+		t.Options = m.sshOptions(target)
+		if m.sshMaster != nil {
+			t.Options = m.sshMaster.Options(t.Destination(), t.Options...)
+		}
+		return t, nil
+	case "ssh-native":
+		var opts []string
+		if m.knownHosts != nil {
+			opts = m.knownHosts.Options(target.DeployHost)
+		}
+		return &transport.NativeSSH{
+			Pool:     m.sshPool,
+			User:     target.DeployUser,
+			Host:     target.DeployHost,
+			Port:     target.Destination.Port,
+			JumpHost: target.JumpHost,
+			Options:  opts,
+		}, nil
+	case "local":
+		return &transport.Local{}, nil
+	case "machinectl":
+		return &transport.Machinectl{Machine: target.DeployHost}, nil
+	}
+
+	return nil, fmt.Errorf("unknown transport %q for deploy host %q", target.Transport, target.DeployHost)
+}
+
 func (m *Model) withVisibleRunner(fn func(*runner.Model)) {
 	var runner *runner.Model
 
@@ -834,7 +1306,7 @@ func (m *Model) withVisibleRunner(fn func(*runner.Model)) {
 	case hostTabDeploy:
 		runner = m.selectedHost.deploy.runner
 	case hostTabRunCmd:
-		runner = m.selectedHost.runCmd.runner
+		runner = m.selectedHost.currentRunSession().runner
 	case hostTabStatus:
 		runner = m.selectedHost.status.runner
 	}
@@ -844,6 +1316,24 @@ func (m *Model) withVisibleRunner(fn func(*runner.Model)) {
 	}
 }
 
+// renderSectionLabel renders a script section label, colored by its result once complete.
+func renderSectionLabel(s runner.Section) string {
+	if !s.Complete {
+		return labelStyle.Render(s.Label)
+	}
+
+	elapsed := s.Elapsed.Round(time.Millisecond).String()
+	switch {
+	case s.Successful():
+		return labelSuccessStyle.Render(s.Label) + " " + subtleStyle.Render(elapsed)
+	case s.TimedOut:
+		return labelFailedStyle.Render(s.Label) + " " + subtleStyle.Render("timed out after "+elapsed)
+	}
+
+	return labelFailedStyle.Render(s.Label) + " " +
+		subtleStyle.Render(fmt.Sprintf("exit %d, %s", s.ExitCode, elapsed))
+}
+
 func tabBorderWithBottom(left, middle, right string) lipgloss.Border {
 	border := lipgloss.RoundedBorder()
 	border.BottomLeft = left
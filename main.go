@@ -67,6 +67,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	keys, err := config.DefaultKeyMap.WithActions(conf.Commands.Actions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Load host list from flake.
 	flakePath := flag.Arg(0)
 	if flakePath == "" {
@@ -90,9 +96,12 @@ func main() {
 	}
 
 	// Launch UI.
-	p := tea.NewProgram(ui.New(*conf, config.DefaultKeyMap, flakePath, hosts), tea.WithAltScreen())
+	model := ui.New(*conf, keys, flakePath, hosts)
+	p := tea.NewProgram(model, tea.WithAltScreen())
 	go p.Send(p)
-	if _, err := p.Run(); err != nil {
+	_, err = p.Run()
+	model.Close()
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}